@@ -0,0 +1,180 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nhan10132020/greenlight/internal/data"
+	"github.com/nhan10132020/greenlight/internal/validator"
+)
+
+// deleteCurrentUserHandler() marks the account of the authenticated user as deleted and revokes
+// all of its credentials. The account is purged after the grace period, logging in before then
+// restores it.
+func (app *application) deleteCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.models.Users.Get(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var input struct {
+		Password string `json:"password"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(input.Password != "", "password", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if !app.confirmPassword(w, r, user, "password", input.Password) {
+		return
+	}
+
+	err = app.models.Users.SoftDelete(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Tokens.DeleteEveryScopeForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.APIKeys.DeleteAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.auditUser(r, "user.delete", user, true)
+
+	env := envelope{
+		"message":       "your account will be permanently deleted, log in again before then to cancel",
+		"deletion_date": time.Now().Add(app.config.users.deletionGracePeriod),
+	}
+
+	err = app.writeJson(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// exportCurrentUserHandler() returns everything stored about the authenticated user as one JSON document
+func (app *application) exportCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.models.Users.Get(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	roles, err := app.models.Roles.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// in jwt mode the access tokens aren't stored, a session is represented by its refresh token
+	scope := data.ScopeAuthentication
+	if app.config.auth.mode == authModeJWT {
+		scope = data.ScopeRefresh
+	}
+
+	tokens, err := app.models.Tokens.GetAllForUser(scope, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	sessions := make([]*data.Session, len(tokens))
+	for i, token := range tokens {
+		sessions[i] = token.Session(false)
+	}
+
+	apiKeys, err := app.models.APIKeys.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	identities, err := app.models.Identities.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	reviews, err := app.models.Reviews.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	watchlist, err := app.models.Watchlists.ExportForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	watched, err := app.models.Viewings.ExportForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{
+		"generated_at": time.Now(),
+		"user":         user,
+		"permissions":  permissions,
+		"roles":        roles,
+		"sessions":     sessions,
+		"api_keys":     apiKeys,
+		"identities":   identities,
+		"reviews":      reviews,
+		"watchlist":    watchlist,
+		"watched":      watched,
+	}
+
+	headers := make(http.Header)
+	headers.Set("Content-Disposition", `attachment; filename="greenlight-export.json"`)
+
+	err = app.writeJson(w, http.StatusOK, envelope{"export": env}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// purgeDeletedUsers() permanently removes, once an hour, the accounts whose grace period is over
+func (app *application) purgeDeletedUsers() {
+	for {
+		time.Sleep(time.Hour)
+
+		purged, err := app.models.Users.PurgeDeleted(time.Now().Add(-app.config.users.deletionGracePeriod))
+		if err != nil {
+			app.logger.PrintError(err, nil)
+			continue
+		}
+
+		if purged > 0 {
+			app.logger.PrintInfo("purged deleted users", map[string]string{
+				"count": strconv.FormatInt(purged, 10),
+			})
+		}
+	}
+}
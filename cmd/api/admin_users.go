@@ -0,0 +1,235 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nhan10132020/greenlight/internal/data"
+	"github.com/nhan10132020/greenlight/internal/validator"
+)
+
+// adminUser exposes to operators the account state hidden from the user's own responses
+type adminUser struct {
+	*data.User
+	FailedLogins     int        `json:"failed_logins"`
+	LockedUntil      *time.Time `json:"locked_until"`
+	AdminLockedUntil *time.Time `json:"admin_locked_until"`
+}
+
+func newAdminUser(user *data.User) adminUser {
+	return adminUser{
+		User:             user,
+		FailedLogins:     user.FailedLogins,
+		LockedUntil:      user.LockedUntil,
+		AdminLockedUntil: user.AdminLockedUntil,
+	}
+}
+
+func (app *application) listUsersHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.UserQuery
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Email = strings.TrimSpace(app.readString(qs, "email", ""))
+	input.Activated = app.readBool(qs, "activated", v)
+	input.CreatedAfter = app.readTime(qs, "created_after", v)
+	input.CreatedBefore = app.readTime(qs, "created_before", v)
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "name", "email", "created_at", "-id", "-name", "-email", "-created_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	users, metadata, err := app.models.Users.GetAll(input.UserQuery, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	adminUsers := make([]adminUser, len(users))
+	for i, user := range users {
+		adminUsers[i] = newAdminUser(user)
+	}
+
+	err = app.writeJson(w, http.StatusOK, envelope{"users": adminUsers, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJson(w, http.StatusOK, envelope{"user": newAdminUser(user)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateUserActivatedHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Activated *bool `json:"activated"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(input.Activated != nil, "activated", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Users.SetActivated(user, *input.Activated)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	action := "user.deactivate"
+	if user.Activated {
+		action = "user.activate"
+	}
+	app.auditUser(r, action, user, false)
+
+	err = app.writeJson(w, http.StatusOK, envelope{"user": newAdminUser(user)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// logoutUserHandler() ends every session of the user and revokes their API keys and
+// impersonation tokens
+func (app *application) logoutUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.deleteSessionTokens(w, r, user.ID) {
+		return
+	}
+
+	err := app.models.APIKeys.DeleteAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllImpersonations(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.auditUser(r, "user.logout", user, false)
+
+	err = app.writeJson(w, http.StatusOK, envelope{"message": "all sessions and API keys of the user were revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// lockUserHandler() refuses every request of the user until the given time. Nothing is revoked,
+// the sessions and API keys of the user work again once the lock ends or is lifted.
+func (app *application) lockUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Until time.Time `json:"until"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(input.Until.After(time.Now()), "until", "must be in the future"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Users.Lock(user, input.Until)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.auditChange(r, "user.lock", "user", user.ID, nil, input)
+
+	err = app.writeJson(w, http.StatusOK, envelope{"user": newAdminUser(user)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) unlockUserByAdminHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Users.ResetLoginFailures(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Users.Unlock(user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.auditUser(r, "user.unlock", user, false)
+
+	user.FailedLogins = 0
+	user.LockedUntil = nil
+
+	err = app.writeJson(w, http.StatusOK, envelope{"user": newAdminUser(user)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteSessionTokens() revokes the tokens of every session of the user, including
+// logins waiting for their second factor
+func (app *application) deleteSessionTokens(w http.ResponseWriter, r *http.Request, userID int64) bool {
+	for _, scope := range []string{data.ScopeAuthentication, data.ScopeRefresh, data.ScopeTwoFactor} {
+		err := app.models.Tokens.DeleteAllForUser(scope, userID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return false
+		}
+	}
+
+	return true
+}
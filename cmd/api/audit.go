@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/nhan10132020/greenlight/internal/audit"
+	"github.com/nhan10132020/greenlight/internal/data"
+	"github.com/nhan10132020/greenlight/internal/validator"
+	"github.com/tomasen/realip"
+)
+
+// request IDs passed in by a proxy are kept when they look sane
+var requestIDRX = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// requestID() gives every request an ID, echoed in the X-Request-ID response header,
+// which ties together its audit events and log lines
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+
+		if !requestIDRX.MatchString(id) {
+			b := make([]byte, 16)
+			_, err := rand.Read(b)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			id = hex.EncodeToString(b)
+		}
+
+		w.Header().Set("X-Request-ID", id)
+		r = app.contextSetRequestID(r, id)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// audit() records an action taken while serving the request. The actor defaults to the
+// authenticated user, and the IP address and request ID are taken from the request. A failure
+// to record is logged rather than failing the request, as the action itself already happened.
+func (app *application) audit(r *http.Request, event audit.Event) {
+	if event.ActorID == nil {
+		if user, ok := r.Context().Value(userContextKey).(*data.User); ok && !user.IsAnonymous() {
+			event.ActorID = &user.ID
+		}
+	}
+
+	if imp := app.contextGetImpersonation(r); imp != nil {
+		event.ImpersonatorID = &imp.impersonator.ID
+	}
+
+	event.IP = realip.FromRequest(r)
+	event.RequestID = app.contextGetRequestID(r)
+
+	err := app.auditLog.Insert(&event)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// auditChange() records an action changing a record, with the fields that changed between
+// before and after. before is nil for a created record and after is nil for a deleted one.
+func (app *application) auditChange(r *http.Request, action, targetType string, targetID int64, before, after interface{}) {
+	b, a, err := audit.Diff(before, after)
+	if err != nil {
+		app.logError(r, err)
+	}
+
+	app.audit(r, audit.Event{
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   strconv.FormatInt(targetID, 10),
+		Before:     b,
+		After:      a,
+	})
+}
+
+// auditUser() records an action concerning a user account, acted by the user themselves
+// when actor is true, otherwise by whoever sent the request
+func (app *application) auditUser(r *http.Request, action string, user *data.User, actor bool) {
+	event := audit.Event{
+		Action:     action,
+		TargetType: "user",
+		TargetID:   strconv.FormatInt(user.ID, 10),
+	}
+	if actor {
+		event.ActorID = &user.ID
+	}
+
+	app.audit(r, event)
+}
+
+func (app *application) listAuditEventsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		audit.Query
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	if qs.Get("actor_id") != "" {
+		actorID := int64(app.readInt(qs, "actor_id", 0, v))
+		input.ActorID = &actorID
+	}
+	input.Action = app.readString(qs, "action", "")
+	input.TargetType = app.readString(qs, "target_type", "")
+	input.TargetID = app.readString(qs, "target_id", "")
+	input.RequestID = app.readString(qs, "request_id", "")
+	input.Since = app.readTime(qs, "since", v)
+	input.Until = app.readTime(qs, "until", v)
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = "-id"
+	input.Filters.SortSafelist = []string{"-id"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	events, metadata, err := app.auditLog.GetAll(input.Query, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJson(w, http.StatusOK, envelope{"events": events, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
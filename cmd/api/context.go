@@ -9,7 +9,15 @@ import (
 
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey   = contextKey("user")
+	tokenContextKey  = contextKey("token")
+	claimsContextKey = contextKey("claims")
+	apiKeyContextKey = contextKey("apiKey")
+
+	impersonationContextKey = contextKey("impersonation")
+	requestIDContextKey     = contextKey("requestID")
+)
 
 // returns a new copy of request with the provided User struct added to the context.
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
@@ -26,3 +34,68 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 
 	return user
 }
+
+// returns a new copy of request with the plaintext authentication token added to the context.
+func (app *application) contextSetToken(r *http.Request, token string) *http.Request {
+	ctx := context.WithValue(r.Context(), tokenContextKey, token)
+	return r.WithContext(ctx)
+}
+
+// retrieves the plaintext authentication token from the request context,
+// returns an empty string if the request wasn't authenticated with a token
+func (app *application) contextGetToken(r *http.Request) string {
+	token, _ := r.Context().Value(tokenContextKey).(string)
+	return token
+}
+
+// returns a new copy of request with the verified JWT claims added to the context.
+func (app *application) contextSetClaims(r *http.Request, claims *jwtClaims) *http.Request {
+	ctx := context.WithValue(r.Context(), claimsContextKey, claims)
+	return r.WithContext(ctx)
+}
+
+// retrieves the JWT claims from the request context,
+// returns nil if the request wasn't authenticated with a JWT
+func (app *application) contextGetClaims(r *http.Request) *jwtClaims {
+	claims, _ := r.Context().Value(claimsContextKey).(*jwtClaims)
+	return claims
+}
+
+// returns a new copy of request with the API key used to authenticate added to the context.
+func (app *application) contextSetAPIKey(r *http.Request, key *data.APIKey) *http.Request {
+	ctx := context.WithValue(r.Context(), apiKeyContextKey, key)
+	return r.WithContext(ctx)
+}
+
+// retrieves the API key from the request context,
+// returns nil if the request wasn't authenticated with an API key
+func (app *application) contextGetAPIKey(r *http.Request) *data.APIKey {
+	key, _ := r.Context().Value(apiKeyContextKey).(*data.APIKey)
+	return key
+}
+
+// returns a new copy of request with the impersonation details added to the context.
+func (app *application) contextSetImpersonation(r *http.Request, imp *impersonation) *http.Request {
+	ctx := context.WithValue(r.Context(), impersonationContextKey, imp)
+	return r.WithContext(ctx)
+}
+
+// retrieves the impersonation details from the request context,
+// returns nil if the request wasn't sent by a staff member acting as the user
+func (app *application) contextGetImpersonation(r *http.Request) *impersonation {
+	imp, _ := r.Context().Value(impersonationContextKey).(*impersonation)
+	return imp
+}
+
+// returns a new copy of request with the request ID added to the context.
+func (app *application) contextSetRequestID(r *http.Request, id string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+	return r.WithContext(ctx)
+}
+
+// retrieves the request ID from the request context,
+// returns an empty string if none was assigned
+func (app *application) contextGetRequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDContextKey).(string)
+	return id
+}
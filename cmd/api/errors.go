@@ -2,13 +2,17 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 func (app *application) logError(r *http.Request, err error) {
 	app.logger.PrintError(err, map[string]string{
 		"request_method": r.Method,
 		"request_url":    r.URL.String(),
+		"request_id":     app.contextGetRequestID(r),
 	})
 }
 
@@ -56,6 +60,33 @@ func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http
 	app.errorResponse(w, r, http.StatusTooManyRequests, mess)
 }
 
+func (app *application) loginBlockedResponse(w http.ResponseWriter, r *http.Request, until time.Time) {
+	w.Header().Set("Retry-After", retryAfter(until))
+	message := "too many failed login attempts from your network, please try again later"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
+func (app *application) accountLockedResponse(w http.ResponseWriter, r *http.Request, until time.Time) {
+	w.Header().Set("Retry-After", retryAfter(until))
+	message := "your user account is temporarily locked because of too many failed login attempts"
+	app.errorResponse(w, r, http.StatusLocked, message)
+}
+
+func (app *application) accountAdminLockedResponse(w http.ResponseWriter, r *http.Request, until time.Time) {
+	w.Header().Set("Retry-After", retryAfter(until))
+	message := "your user account has been locked by an administrator"
+	app.errorResponse(w, r, http.StatusLocked, message)
+}
+
+// retryAfter() formats the delay until a point in time as a Retry-After value in seconds
+func retryAfter(until time.Time) string {
+	seconds := int(math.Ceil(time.Until(until).Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	return strconv.Itoa(seconds)
+}
+
 func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
 	message := "invalid authentication credentials"
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
@@ -67,6 +98,12 @@ func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
 }
 
+func (app *application) invalidAPIKeyResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "ApiKey")
+	message := "invalid or expired API key"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
 func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
 	message := "you must be authenticated to access this resource"
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
@@ -81,3 +118,23 @@ func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Requ
 	messages := "your user account doesn't have the necessary permissions to access this resource"
 	app.errorResponse(w, r, http.StatusForbidden, messages)
 }
+
+func (app *application) registrationClosedResponse(w http.ResponseWriter, r *http.Request) {
+	messages := "registration of new accounts is currently closed"
+	app.errorResponse(w, r, http.StatusForbidden, messages)
+}
+
+func (app *application) readOnlyImpersonationResponse(w http.ResponseWriter, r *http.Request) {
+	messages := "this impersonation token is read-only"
+	app.errorResponse(w, r, http.StatusForbidden, messages)
+}
+
+func (app *application) identityEmailMissingResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the identity provider didn't share a valid email address for your account"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) identityConflictResponse(w http.ResponseWriter, r *http.Request) {
+	message := "an account with this email address already exists, log in with your password instead"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
@@ -9,9 +9,12 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/nhan10132020/greenlight/internal/data"
 	"github.com/nhan10132020/greenlight/internal/validator"
+	"github.com/tomasen/realip"
 )
 
 func (app *application) readIDParam(r *http.Request) (int64, error) {
@@ -144,6 +147,50 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return i
 }
 
+// readBool() returns nil when the parameter is absent, so that a filter on it can be left out
+func (app *application) readBool(qs url.Values, key string, v *validator.Validator) *bool {
+	s := qs.Get(key)
+
+	if s == "" {
+		return nil
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return nil
+	}
+
+	return &b
+}
+
+// readTime() accepts either an RFC 3339 timestamp or a date, and returns nil when the parameter is absent
+func (app *application) readTime(qs url.Values, key string, v *validator.Validator) *time.Time {
+	s := qs.Get(key)
+
+	if s == "" {
+		return nil
+	}
+
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		t, err := time.Parse(layout, s)
+		if err == nil {
+			return &t
+		}
+	}
+
+	v.AddError(key, "must be an RFC 3339 timestamp or a YYYY-MM-DD date")
+	return nil
+}
+
+// clientInfo() describes the client which sent the request, for recording alongside issued tokens
+func (app *application) clientInfo(r *http.Request) data.ClientInfo {
+	return data.ClientInfo{
+		IP:        realip.FromRequest(r),
+		UserAgent: r.UserAgent(),
+	}
+}
+
 // launch backround go routine
 func (app *application) background(fn func()) {
 	app.wg.Add(1)
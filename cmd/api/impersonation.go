@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nhan10132020/greenlight/internal/data"
+	"github.com/nhan10132020/greenlight/internal/validator"
+)
+
+// the longest an impersonation token may last
+const maxImpersonationTTL = time.Hour
+
+// impersonation describes a request sent by a staff member acting as another user
+type impersonation struct {
+	impersonator *data.User
+	readOnly     bool
+}
+
+// createImpersonationTokenHandler() issues a short-lived authentication token acting as the user
+func (app *application) createImpersonationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	impersonator := app.contextGetUser(r)
+
+	// tokens can't be chained, an impersonated user could otherwise hand out further tokens
+	if app.contextGetImpersonation(r) != nil {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Duration *string `json:"duration"`
+		ReadOnly *bool   `json:"read_only"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ttl := 15 * time.Minute
+	readOnly := true
+
+	v := validator.New()
+
+	if input.Duration != nil {
+		ttl, err = time.ParseDuration(*input.Duration)
+		if err != nil {
+			v.AddError("duration", "must be a duration such as 15m")
+		} else {
+			v.Check(ttl > 0, "duration", "must be greater than zero")
+			v.Check(ttl <= maxImpersonationTTL, "duration", "must not be more than "+maxImpersonationTTL.String())
+		}
+	}
+	if input.ReadOnly != nil {
+		readOnly = *input.ReadOnly
+	}
+
+	v.Check(user.ID != impersonator.ID, "user", "must not be yourself")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// acting as another administrator would hand out their privileges
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	for _, code := range []string{"permissions:admin", "users:admin", "users:impersonate"} {
+		if permissions.Include(code) {
+			app.notPermittedResponse(w, r)
+			return
+		}
+	}
+
+	token, err := app.models.Tokens.NewImpersonation(user.ID, impersonator.ID, ttl, readOnly, app.clientInfo(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.auditChange(r, "user.impersonate", "user", user.ID, nil, map[string]interface{}{
+		"read_only": readOnly,
+		"expiry":    token.Expiry,
+	})
+
+	app.logger.PrintInfo("impersonation token issued", map[string]string{
+		"impersonator_id": strconv.FormatInt(impersonator.ID, 10),
+		"user_id":         strconv.FormatInt(user.ID, 10),
+		"read_only":       strconv.FormatBool(readOnly),
+		"expiry":          token.Expiry.Format(time.RFC3339),
+	})
+
+	err = app.writeJson(w, http.StatusCreated, envelope{"authentication_token": token, "read_only": readOnly}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// authenticateImpersonation() authenticates a request carrying an impersonation token. Both the
+// impersonated user and the impersonator go into the request context, writes are refused when the
+// token is read-only, and every request is logged with both identities.
+func (app *application) authenticateImpersonation(w http.ResponseWriter, r *http.Request, next http.Handler, tokenPlaintext string) {
+	token, err := app.models.Tokens.GetImpersonation(tokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// either user may have been purged since the token was issued
+	user, err := app.models.Users.Get(token.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	impersonator, err := app.models.Users.Get(*token.ImpersonatorID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// a deletion request on either side ends the impersonation like it revokes sessions
+	if user.DeletedAt != nil || impersonator.DeletedAt != nil {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	// a lock on either side ends the impersonation
+	for _, u := range []*data.User{user, impersonator} {
+		if u.IsAdminLocked() {
+			app.accountAdminLockedResponse(w, r, *u.AdminLockedUntil)
+			return
+		}
+	}
+
+	app.logger.PrintInfo("impersonated request", map[string]string{
+		"impersonator_id": strconv.FormatInt(impersonator.ID, 10),
+		"user_id":         strconv.FormatInt(user.ID, 10),
+		"request_method":  r.Method,
+		"request_url":     r.URL.String(),
+	})
+
+	// revoking the impersonation token itself is allowed even when read-only
+	logout := r.Method == http.MethodDelete && r.URL.Path == "/v1/tokens/authentication"
+
+	if token.ReadOnly && !logout && r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodOptions {
+		app.readOnlyImpersonationResponse(w, r)
+		return
+	}
+
+	r = app.contextSetUser(r, user)
+	r = app.contextSetToken(r, tokenPlaintext)
+	r = app.contextSetImpersonation(r, &impersonation{impersonator: impersonator, readOnly: token.ReadOnly})
+
+	next.ServeHTTP(w, r)
+}
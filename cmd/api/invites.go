@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/nhan10132020/greenlight/internal/data"
+	"github.com/nhan10132020/greenlight/internal/validator"
+)
+
+const (
+	registrationModeOpen   = "open"
+	registrationModeInvite = "invite"
+	registrationModeClosed = "closed"
+)
+
+func (app *application) createInviteHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email       *string  `json:"email"`
+		Expiry      *string  `json:"expiry"`
+		MaxUses     *int     `json:"max_uses"`
+		Permissions []string `json:"permissions"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	creator := app.contextGetUser(r)
+
+	invite := &data.Invite{
+		CreatedBy:   &creator.ID,
+		Email:       input.Email,
+		Expiry:      time.Now().Add(7 * 24 * time.Hour),
+		MaxUses:     1,
+		Permissions: input.Permissions,
+	}
+
+	v := validator.New()
+
+	if input.Expiry != nil {
+		ttl, err := time.ParseDuration(*input.Expiry)
+		if err != nil {
+			v.AddError("expiry", "must be a duration such as 72h")
+		}
+		invite.Expiry = time.Now().Add(ttl)
+	}
+	if input.MaxUses != nil {
+		invite.MaxUses = *input.MaxUses
+	}
+
+	if data.ValidateInvite(v, invite); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// an invite can't hand out more than its creator holds
+	permissions, err := app.userPermissions(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	for _, code := range invite.Permissions {
+		v.Check(permissions.Include(code), "permissions", "must only contain permissions granted to your account")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Invites.Insert(invite)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// the code itself must not end up in the audit trail
+	app.auditChange(r, "invite.create", "invite", invite.ID, nil, map[string]interface{}{
+		"email":       invite.Email,
+		"expiry":      invite.Expiry,
+		"max_uses":    invite.MaxUses,
+		"permissions": invite.Permissions,
+	})
+
+	err = app.writeJson(w, http.StatusCreated, envelope{"invite": invite}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listInvitesHandler(w http.ResponseWriter, r *http.Request) {
+	invites, err := app.models.Invites.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJson(w, http.StatusOK, envelope{"invites": invites}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteInviteHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Invites.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.auditChange(r, "invite.delete", "invite", id, nil, nil)
+
+	err = app.writeJson(w, http.StatusOK, envelope{"message": "invite successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
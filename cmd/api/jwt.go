@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nhan10132020/greenlight/internal/data"
+	"github.com/nhan10132020/greenlight/internal/jwt"
+)
+
+const (
+	authModeToken = "token"
+	authModeJWT   = "jwt"
+)
+
+// jwtClaims is the payload of the access tokens issued in jwt auth mode. It carries
+// everything authenticate and requirePermission need, so no database lookup is done
+// while the token is valid.
+type jwtClaims struct {
+	Subject     string   `json:"sub"`
+	Activated   bool     `json:"activated"`
+	Permissions []string `json:"permissions"`
+	Family      string   `json:"sid"` // refresh family the token was issued from
+	IssuedAt    int64    `json:"iat"`
+	Expiry      int64    `json:"exp"`
+}
+
+func (c *jwtClaims) user() (*data.User, error) {
+	id, err := strconv.ParseInt(c.Subject, 10, 64)
+	if err != nil || id < 1 {
+		return nil, jwt.ErrInvalidToken
+	}
+
+	return &data.User{ID: id, Activated: c.Activated}, nil
+}
+
+// loadJWTKeys() builds the key set from "kid=path" pairs
+func loadJWTKeys(files []string, signingKeyID string) (*jwt.KeySet, error) {
+	keys := make([]*jwt.Key, 0, len(files))
+
+	for _, file := range files {
+		id, path, ok := strings.Cut(file, "=")
+		if !ok || id == "" || path == "" {
+			return nil, fmt.Errorf("invalid jwt key %q, expected kid=path", file)
+		}
+
+		key, err := jwt.LoadKeyFile(id, path)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return jwt.NewKeySet(signingKeyID, keys...)
+}
+
+// newJWT() signs an access token for the user embedding its current permissions
+func (app *application) newJWT(user *data.User, family string) (*data.Token, error) {
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	expiry := now.Add(app.config.tokens.authenticationTTL)
+
+	claims := jwtClaims{
+		Subject:     strconv.FormatInt(user.ID, 10),
+		Activated:   user.Activated,
+		Permissions: permissions,
+		Family:      family,
+		IssuedAt:    now.Unix(),
+		Expiry:      expiry.Unix(),
+	}
+
+	plaintext, err := app.jwtKeys.Sign(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return &data.Token{Plaintext: plaintext, Expiry: expiry, UserID: user.ID, Scope: data.ScopeAuthentication, Family: family}, nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/nhan10132020/greenlight/internal/data"
+)
+
+// listenForPermissionChanges() keeps the permission cache of this instance in step with changes
+// made through any other instance, which announce them with NOTIFY on data.PermissionsChannel
+func (app *application) listenForPermissionChanges(cache *data.PermissionCache) error {
+	listener := pq.NewListener(app.config.db.dsn, 10*time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"listener": data.PermissionsChannel})
+		}
+	})
+
+	err := listener.Listen(data.PermissionsChannel)
+	if err != nil {
+		listener.Close()
+		return err
+	}
+
+	go func() {
+		for {
+			select {
+			case n := <-listener.Notify:
+				// a nil notification means the connection was re-established and
+				// notifications may have been missed in the meantime
+				if n == nil {
+					cache.InvalidateAll()
+					continue
+				}
+				cache.InvalidatePayload(n.Extra)
+			case <-time.After(90 * time.Second):
+				// check the connection is still alive when it has been quiet for a while
+				go listener.Ping()
+			}
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/nhan10132020/greenlight/internal/data"
+	"github.com/nhan10132020/greenlight/internal/validator"
+)
+
+// createMagicLinkTokenHandler() emails a single-use login token to the address, when the
+// passwordless login is enabled
+func (app *application) createMagicLinkTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.config.auth.magicLink {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if !app.emailThrottle.Allow(input.Email) {
+		app.rateLimitExceededResponse(w, r)
+		return
+	}
+
+	// as for activation tokens the response doesn't tell whether an account exists
+	env := envelope{"message": "if an account exists for this email address, an email will be sent containing a login link"}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if user != nil && !user.IsLocked() && !user.IsAdminLocked() {
+		token, err := app.models.Tokens.New(user.ID, 15*time.Minute, data.ScopeMagicLink)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.background(func() {
+			data := map[string]interface{}{
+				"magicLinkToken": token.Plaintext,
+			}
+
+			err = app.mailer.Send(user.Email, "token_magic_link.html", data)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		})
+	}
+
+	err = app.writeJson(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// exchangeMagicLinkTokenHandler() logs the user in with a magic-link token. Receiving the
+// token proves the email address belongs to the user, so the account is activated as well.
+func (app *application) exchangeMagicLinkTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.config.auth.magicLink {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopeMagicLink, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired magic link token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// the token is single-use, any other link sent to the user is spent with it
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeMagicLink, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if app.refuseLockedAccount(w, r, user) {
+		return
+	}
+
+	if !user.Activated {
+		user.Activated = true
+		err = app.models.Users.Update(user)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrEditConflict):
+				app.editConflictResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		err = app.models.Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	app.completeLogin(w, r, user)
+}
@@ -3,18 +3,24 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"expvar"
 	"flag"
 	"fmt"
+	"math"
 	"os"
 	"runtime"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/nhan10132020/greenlight/internal/audit"
 	"github.com/nhan10132020/greenlight/internal/data"
 	"github.com/nhan10132020/greenlight/internal/jsonlog"
+	"github.com/nhan10132020/greenlight/internal/jwt"
 	"github.com/nhan10132020/greenlight/internal/mailer"
+	"github.com/nhan10132020/greenlight/internal/oidc"
+	"github.com/nhan10132020/greenlight/internal/pwned"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
@@ -33,10 +39,20 @@ type config struct {
 		maxIdleConns int
 		maxIdleTime  string
 	}
+	permissions struct {
+		cacheTTL time.Duration
+	}
+	users struct {
+		deletionGracePeriod time.Duration
+	}
+	registration struct {
+		mode string
+	}
 	limiter struct {
-		rps     float64
-		burst   int
-		enabled bool
+		rps           float64
+		burst         int
+		enabled       bool
+		emailsPerHour int
 	}
 	smtp struct {
 		host     string
@@ -48,6 +64,36 @@ type config struct {
 	cors struct {
 		trustedOrigins []string
 	}
+	tokens struct {
+		authenticationTTL time.Duration
+		refreshTTL        time.Duration
+	}
+	auth struct {
+		mode          string
+		jwtKeys       []string
+		jwtSigningKey string
+		magicLink     bool
+	}
+	oidc struct {
+		issuer       string
+		clientID     string
+		clientSecret string
+		redirectURL  string
+	}
+	lockout struct {
+		account data.LockoutPolicy
+		ip      data.LockoutPolicy
+	}
+	password struct {
+		hasher              string
+		bcryptCost          int
+		argon2idMemory      uint
+		argon2idIterations  uint
+		argon2idParallelism uint
+		minScore            int
+		disallowPersonal    bool
+		breachedFile        string
+	}
 }
 
 type application struct {
@@ -56,6 +102,11 @@ type application struct {
 	models data.Models
 	mailer mailer.Mailer
 	wg     sync.WaitGroup
+
+	emailThrottle *emailThrottle
+	jwtKeys       *jwt.KeySet
+	auditLog      audit.Log
+	oidc          *oidc.Provider
 }
 
 func main() {
@@ -73,10 +124,20 @@ func main() {
 	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
 	flag.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max connection idle time")
 
+	// permission cache setting
+	flag.DurationVar(&cfg.permissions.cacheTTL, "permissions-cache-ttl", time.Minute, "How long user permissions are cached, 0 disables the cache")
+
+	// account deletion setting
+	flag.DurationVar(&cfg.users.deletionGracePeriod, "users-deletion-grace-period", 30*24*time.Hour, "How long a deleted account can still be restored before it is purged")
+
+	// registration setting
+	flag.StringVar(&cfg.registration.mode, "registration-mode", registrationModeOpen, "Who may register (open|invite|closed)")
+
 	// rate-limiter setting
 	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
 	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
 	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
+	flag.IntVar(&cfg.limiter.emailsPerHour, "limiter-emails-per-hour", 3, "Maximum token emails sent to one address per hour")
 
 	// SMTP setting
 	flag.StringVar(&cfg.smtp.host, "smtp-host", "sandbox.smtp.mailtrap.io", "SMTP host")
@@ -91,11 +152,52 @@ func main() {
 		return nil
 	})
 
+	// token lifetime setting
+	flag.DurationVar(&cfg.tokens.authenticationTTL, "token-authentication-ttl", 15*time.Minute, "Authentication token lifetime, kept short as sessions are renewed with refresh tokens")
+	flag.DurationVar(&cfg.tokens.refreshTTL, "token-refresh-ttl", 30*24*time.Hour, "Refresh token lifetime")
+
+	// authentication mode setting
+	flag.StringVar(&cfg.auth.mode, "auth-mode", authModeToken, "Authentication mode (token|jwt)")
+	flag.Func("jwt-keys", "JWT verification keys as kid=path pairs (space separated)", func(val string) error {
+		cfg.auth.jwtKeys = strings.Fields(val)
+		return nil
+	})
+	flag.StringVar(&cfg.auth.jwtSigningKey, "jwt-signing-key", "", "kid of the JWT key used for signing")
+	flag.BoolVar(&cfg.auth.magicLink, "auth-magic-link", false, "Enable passwordless login with emailed magic links")
+
+	// single sign-on setting
+	flag.StringVar(&cfg.oidc.issuer, "oidc-issuer", "", "OpenID Connect issuer URL, empty disables single sign-on")
+	flag.StringVar(&cfg.oidc.clientID, "oidc-client-id", "", "OpenID Connect client ID")
+	flag.StringVar(&cfg.oidc.clientSecret, "oidc-client-secret", "", "OpenID Connect client secret, empty for a public client")
+	flag.StringVar(&cfg.oidc.redirectURL, "oidc-redirect-url", "", "URL the identity provider redirects to with the authorization code")
+
+	// login lockout setting
+	flag.IntVar(&cfg.lockout.account.MaxAttempts, "lockout-max-attempts", 5, "Failed logins before an account is locked")
+	flag.DurationVar(&cfg.lockout.account.Duration, "lockout-duration", time.Minute, "Initial account lockout, doubled on every further failure")
+	flag.DurationVar(&cfg.lockout.account.MaxDuration, "lockout-max-duration", 24*time.Hour, "Maximum account lockout")
+	flag.IntVar(&cfg.lockout.ip.MaxAttempts, "lockout-ip-max-attempts", 20, "Failed logins before an IP address is blocked")
+
+	// password hashing setting
+	flag.StringVar(&cfg.password.hasher, "password-hasher", "argon2id", "Password hashing algorithm (argon2id|bcrypt)")
+	flag.IntVar(&cfg.password.bcryptCost, "password-bcrypt-cost", 12, "bcrypt cost")
+	flag.UintVar(&cfg.password.argon2idMemory, "password-argon2id-memory", 64*1024, "argon2id memory in KiB")
+	flag.UintVar(&cfg.password.argon2idIterations, "password-argon2id-iterations", 3, "argon2id iterations")
+	flag.UintVar(&cfg.password.argon2idParallelism, "password-argon2id-parallelism", 2, "argon2id parallelism")
+
+	// password policy setting
+	flag.IntVar(&cfg.password.minScore, "password-min-score", 2, "Minimum password strength score from 0 to 4, 0 disables the check")
+	flag.BoolVar(&cfg.password.disallowPersonal, "password-disallow-personal", true, "Refuse passwords containing the user's name or email address")
+	flag.StringVar(&cfg.password.breachedFile, "password-breached-file", "", "Path to a sorted SHA-1 breached-password file (HIBP format), empty disables the check")
+
 	// display version setting
 	displayVersion := flag.Bool("version", false, "Display version and exit")
 
 	flag.Parse()
 
+	// an IP address backs off on the same schedule as an account, it is only allowed more attempts
+	cfg.lockout.ip.Duration = cfg.lockout.account.Duration
+	cfg.lockout.ip.MaxDuration = cfg.lockout.account.MaxDuration
+
 	if *displayVersion {
 		fmt.Printf("Version:\t%s\n", version)
 		fmt.Printf("Build time:\t%s\n", buildTime)
@@ -104,6 +206,88 @@ func main() {
 
 	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
 
+	switch cfg.password.hasher {
+	case "argon2id":
+		// the flags are narrowed to the parameter types below, out of range values would wrap
+		// around and zero ones make hashing panic on the first login
+		switch {
+		case cfg.password.argon2idMemory < 1 || cfg.password.argon2idMemory > math.MaxUint32:
+			logger.PrintFatal(fmt.Errorf("password-argon2id-memory must be between 1 and %d", uint64(math.MaxUint32)), nil)
+		case cfg.password.argon2idIterations < 1 || cfg.password.argon2idIterations > math.MaxUint32:
+			logger.PrintFatal(fmt.Errorf("password-argon2id-iterations must be between 1 and %d", uint64(math.MaxUint32)), nil)
+		case cfg.password.argon2idParallelism < 1 || cfg.password.argon2idParallelism > math.MaxUint8:
+			logger.PrintFatal(fmt.Errorf("password-argon2id-parallelism must be between 1 and %d", math.MaxUint8), nil)
+		}
+
+		data.SetPasswordHasher(data.Argon2idHasher{Params: data.Argon2idParams{
+			Memory:      uint32(cfg.password.argon2idMemory),
+			Iterations:  uint32(cfg.password.argon2idIterations),
+			Parallelism: uint8(cfg.password.argon2idParallelism),
+			SaltLength:  16,
+			KeyLength:   32,
+		}})
+	case "bcrypt":
+		data.SetPasswordHasher(data.BcryptHasher{Cost: cfg.password.bcryptCost})
+	default:
+		logger.PrintFatal(fmt.Errorf("invalid password hasher %q", cfg.password.hasher), nil)
+	}
+
+	switch cfg.registration.mode {
+	case registrationModeOpen, registrationModeInvite, registrationModeClosed:
+	default:
+		logger.PrintFatal(fmt.Errorf("invalid registration mode %q", cfg.registration.mode), nil)
+	}
+
+	policy := data.PasswordPolicy{
+		MinScore:         cfg.password.minScore,
+		DisallowPersonal: cfg.password.disallowPersonal,
+	}
+	if cfg.password.breachedFile != "" {
+		breached, err := pwned.Open(cfg.password.breachedFile)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		defer breached.Close()
+
+		policy.Breached = breached
+		logger.PrintInfo("breached password list loaded", map[string]string{"path": cfg.password.breachedFile})
+	}
+	data.SetPasswordPolicy(policy)
+
+	var jwtKeys *jwt.KeySet
+	switch cfg.auth.mode {
+	case authModeToken:
+	case authModeJWT:
+		keys, err := loadJWTKeys(cfg.auth.jwtKeys, cfg.auth.jwtSigningKey)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		jwtKeys = keys
+	default:
+		logger.PrintFatal(fmt.Errorf("invalid auth mode %q", cfg.auth.mode), nil)
+	}
+
+	var provider *oidc.Provider
+	if cfg.oidc.issuer != "" {
+		if cfg.oidc.clientID == "" || cfg.oidc.redirectURL == "" {
+			logger.PrintFatal(errors.New("oidc-client-id and oidc-redirect-url are required with oidc-issuer"), nil)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		discovered, err := oidc.Discover(ctx, oidc.Config{
+			Issuer:       cfg.oidc.issuer,
+			ClientID:     cfg.oidc.clientID,
+			ClientSecret: cfg.oidc.clientSecret,
+			RedirectURL:  cfg.oidc.redirectURL,
+		})
+		cancel()
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		provider = discovered
+		logger.PrintInfo("identity provider discovered", map[string]string{"issuer": cfg.oidc.issuer})
+	}
+
 	db, postgresDB, err := openDB(cfg)
 	if err != nil {
 		logger.PrintFatal(err, nil)
@@ -128,13 +312,37 @@ func main() {
 		return time.Now().Unix()
 	}))
 
+	var permissionCache *data.PermissionCache
+	if cfg.permissions.cacheTTL > 0 {
+		permissionCache = data.NewPermissionCache(cfg.permissions.cacheTTL)
+
+		// Publish the permission cache size and hit/miss counters.
+		expvar.Publish("permission_cache", expvar.Func(func() interface{} {
+			return permissionCache.Stats()
+		}))
+	}
+
 	app := &application{
 		config: cfg,
 		logger: logger,
-		models: data.NewModels(db),
+		models: data.NewModels(db, permissionCache),
 		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+
+		emailThrottle: newEmailThrottle(cfg.limiter.emailsPerHour, time.Hour),
+		jwtKeys:       jwtKeys,
+		auditLog:      audit.Log{DB: db},
+		oidc:          provider,
 	}
 
+	if permissionCache != nil {
+		err = app.listenForPermissionChanges(permissionCache)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+	}
+
+	go app.purgeDeletedUsers()
+
 	err = app.serve()
 	if err != nil {
 		logger.PrintFatal(err, nil)
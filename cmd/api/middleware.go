@@ -107,6 +107,11 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		}
 
 		headerParts := strings.Split(authorizationHeader, " ")
+		if len(headerParts) == 2 && headerParts[0] == "ApiKey" {
+			app.authenticateAPIKey(w, r, next, headerParts[1])
+			return
+		}
+
 		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
 			app.invalidAuthenticationTokenResponse(w, r)
 			return
@@ -114,6 +119,52 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 
 		token := headerParts[1]
 
+		// in jwt mode signed tokens are verified in-process, opaque tokens issued before
+		// switching modes are still looked up in the database below
+		if app.config.auth.mode == authModeJWT && strings.Count(token, ".") == 2 {
+			var claims jwtClaims
+
+			err := app.jwtKeys.Verify(token, &claims)
+			if err != nil {
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+
+			user, err := claims.user()
+			if err != nil {
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+
+			// the only lookup done for a JWT, a deletion request or a lock must take effect
+			// before the token expires
+			state, err := app.models.Users.GetAccessState(user.ID)
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrRecordNotFound):
+					app.invalidAuthenticationTokenResponse(w, r)
+				default:
+					app.serverErrorResponse(w, r, err)
+				}
+				return
+			}
+			if state.DeletedAt != nil {
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+			if state.IsAdminLocked() {
+				app.accountAdminLockedResponse(w, r, *state.AdminLockedUntil)
+				return
+			}
+
+			r = app.contextSetUser(r, user)
+			r = app.contextSetToken(r, token)
+			r = app.contextSetClaims(r, &claims)
+
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		v := validator.New()
 
 		if data.ValidateTokenPlaintext(v, token); !v.Valid() {
@@ -125,7 +176,8 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
-				app.invalidAuthenticationTokenResponse(w, r)
+				// not a session token, it may still be an impersonation token issued to support staff
+				app.authenticateImpersonation(w, r, next, token)
 			default:
 				app.serverErrorResponse(w, r, err)
 			}
@@ -133,12 +185,73 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
+		if user.IsAdminLocked() {
+			app.accountAdminLockedResponse(w, r, *user.AdminLockedUntil)
+			return
+		}
+
+		// failing to record the last use of a token shouldn't fail the request itself
+		err = app.models.Tokens.Touch(token)
+		if err != nil {
+			app.logError(r, err)
+		}
+
 		r = app.contextSetUser(r, user)
+		r = app.contextSetToken(r, token)
 
 		next.ServeHTTP(w, r)
 	})
 }
 
+// authenticateAPIKey() authenticates machine clients sending an "Authorization: ApiKey <key>" header
+func (app *application) authenticateAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, keyPlaintext string) {
+	v := validator.New()
+
+	if data.ValidateAPIKeyPlaintext(v, keyPlaintext); !v.Valid() {
+		app.invalidAPIKeyResponse(w, r)
+		return
+	}
+
+	key, err := app.models.APIKeys.GetForKey(keyPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAPIKeyResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.models.Users.Get(key.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAPIKeyResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// keys stop working as soon as the deletion of the account is requested, until
+	// a login during the grace period restores it
+	if user.DeletedAt != nil {
+		app.invalidAPIKeyResponse(w, r)
+		return
+	}
+
+	if user.IsAdminLocked() {
+		app.accountAdminLockedResponse(w, r, *user.AdminLockedUntil)
+		return
+	}
+
+	r = app.contextSetUser(r, user)
+	r = app.contextSetAPIKey(r, key)
+
+	next.ServeHTTP(w, r)
+}
+
 func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user := app.contextGetUser(r)
@@ -167,10 +280,24 @@ func (app *application) requireActivatedUser(next http.HandlerFunc) http.Handler
 	return app.requireAuthenticatedUser(fn)
 }
 
+// requireSession() rejects requests authenticated with an API key or sent by a staff member
+// impersonating the user, for endpoints managing credentials which only the user may reach
+func (app *application) requireSession(next http.HandlerFunc) http.HandlerFunc {
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.contextGetAPIKey(r) != nil || app.contextGetImpersonation(r) != nil {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+
+	return app.requireActivatedUser(fn)
+}
+
 func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
 	fn := func(w http.ResponseWriter, r *http.Request) {
-		user := app.contextGetUser(r)
-		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+		permissions, err := app.userPermissions(r)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
@@ -186,6 +313,27 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 	return app.requireActivatedUser(fn)
 }
 
+// userPermissions() returns the permissions of the user who sent the request, taken from
+// the JWT claims when present instead of querying the database, and narrowed down to the
+// codes of the API key when the request was authenticated with one
+func (app *application) userPermissions(r *http.Request) (data.Permissions, error) {
+	if claims := app.contextGetClaims(r); claims != nil {
+		return data.Permissions(claims.Permissions), nil
+	}
+
+	user := app.contextGetUser(r)
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if key := app.contextGetAPIKey(r); key != nil {
+		return key.Restrict(permissions), nil
+	}
+
+	return permissions, nil
+}
+
 func (app *application) enableCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("Vary", "Origin")
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/nhan10132020/greenlight/internal/data"
 	"github.com/nhan10132020/greenlight/internal/validator"
@@ -32,9 +33,19 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 		Genres:  input.Genres,
 	}
 
+	genres, err := app.models.Genres.Taxonomy()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// genres may be given by name or alias, movies store the slugs
+	movie.Genres = genres.ResolveAll(movie.Genres)
+
 	v := validator.New()
 
-	if data.ValidateMovie(v, movie); !v.Valid() {
+	data.ValidateMovie(v, movie)
+	if data.ValidateMovieGenres(v, movie.Genres, genres); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
@@ -45,6 +56,8 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	app.auditChange(r, "movie.create", "movie", movie.ID, nil, movie)
+
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
 
@@ -97,6 +110,9 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// kept for the audit trail before the input is applied
+	before := *movie
+
 	if r.Header.Get("X-Expected-Version") != "" {
 		if strconv.FormatInt(int64(movie.Version), 32) != r.Header.Get("X-Expected-Version") {
 			app.editConflictResponse(w, r)
@@ -130,12 +146,23 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 	if input.Runtime != nil {
 		movie.Runtime = *input.Runtime
 	}
-	if input.Genres != nil {
-		movie.Genres = input.Genres
-	}
 
 	v := validator.New()
 
+	// only new genres are checked against the taxonomy, a movie may still have genres
+	// from before it which can't be resolved
+	if input.Genres != nil {
+		genres, err := app.models.Genres.Taxonomy()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		// genres may be given by name or alias, movies store the slugs
+		movie.Genres = genres.ResolveAll(input.Genres)
+		data.ValidateMovieGenres(v, movie.Genres, genres)
+	}
+
 	if data.ValidateMovie(v, movie); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
@@ -152,6 +179,8 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	app.auditChange(r, "movie.update", "movie", movie.ID, &before, movie)
+
 	err = app.writeJson(w, http.StatusOK, envelope{"movie": movie}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -165,6 +194,18 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// read first so the audit trail keeps what was deleted
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
 	err = app.models.Movies.Delete(id)
 	if err != nil {
 		switch {
@@ -176,6 +217,8 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	app.auditChange(r, "movie.delete", "movie", movie.ID, movie, nil)
+
 	err = app.writeJson(w, http.StatusOK, envelope{"message": "movie successfully deleted"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -185,8 +228,7 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 
 func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title  string
-		Genres []string
+		data.MovieQuery
 		data.Filters
 	}
 
@@ -196,17 +238,41 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 
 	input.Title = app.readString(qs, "title", "")
 	input.Genres = app.readCSV(qs, "genres", []string{})
+	input.Watched = app.readBool(qs, "watched", v)
+	input.UserID = app.contextGetUser(r).ID
+	input.PersonID = int64(app.readInt(qs, "person", 0, v))
+	input.PersonRole = app.readString(qs, "person_role", "")
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
 	input.Filters.Sort = app.readString(qs, "sort", "id")
-	input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+	input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "average_rating", "rating_count", "-id", "-title", "-year", "-runtime", "-average_rating", "-rating_count"}
+
+	if input.PersonRole != "" {
+		v.Check(input.PersonID != 0, "person_role", "must be used together with person")
+		v.Check(validator.In(input.PersonRole, data.CreditRoles...), "person_role", "must be one of "+strings.Join(data.CreditRoles, ", "))
+	}
+
+	if len(input.Genres) > 0 {
+		genres, err := app.models.Genres.Taxonomy()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		// movies store the slugs, so the filter accepts the names and aliases too
+		for i, name := range input.Genres {
+			slug, ok := genres.Resolve(name)
+			v.Check(ok, "genres", fmt.Sprintf("%q is not a known genre", name))
+			input.Genres[i] = slug
+		}
+	}
 
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	movies, metadata, err := app.models.Movies.GetAll(input.MovieQuery, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
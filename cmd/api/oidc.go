@@ -0,0 +1,220 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nhan10132020/greenlight/internal/data"
+	"github.com/nhan10132020/greenlight/internal/oidc"
+	"github.com/nhan10132020/greenlight/internal/validator"
+)
+
+// createOIDCAuthorizationHandler() starts a single sign-on login: it returns the URL of the
+// identity provider the client sends the user to. The provider sends the user back to the
+// configured redirect URL with a code and the state, which the client exchanges for tokens.
+func (app *application) createOIDCAuthorizationHandler(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	verifier, err := oidc.NewVerifier()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	nonce, err := oidc.NewNonce()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	state, err := app.models.OIDCStates.New(10*time.Minute, verifier, nonce)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{
+		"authorization_url": app.oidc.AuthCodeURL(state.Plaintext, nonce, verifier),
+		"state":             state.Plaintext,
+		"expiry":            state.Expiry,
+	}
+
+	err = app.writeJson(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// exchangeOIDCCodeHandler() completes a single sign-on login with the code the identity
+// provider returned, creating the account on the first login
+func (app *application) exchangeOIDCCodeHandler(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Code  string `json:"code"`
+		State string `json:"state"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.Code != "", "code", "must be provided")
+	v.Check(len(input.Code) <= 2048, "code", "must not be more than 2048 bytes long")
+	v.Check(input.State != "", "state", "must be provided")
+	v.Check(len(input.State) == 26, "state", "must be 26 bytes long")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// the state is single-use, a replayed code fails here before reaching the provider
+	state, err := app.models.OIDCStates.Consume(input.State)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("state", "invalid or expired login state")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	claims, err := app.oidc.Exchange(r.Context(), input.Code, state.CodeVerifier, state.Nonce)
+	if err != nil {
+		switch {
+		case errors.Is(err, oidc.ErrInvalidGrant):
+			v.AddError("code", "invalid or expired authorization code")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, oidc.ErrInvalidIDToken):
+			app.logError(r, err)
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user, ok := app.userForIdentity(w, r, claims)
+	if !ok {
+		return
+	}
+
+	if app.refuseLockedAccount(w, r, user) {
+		return
+	}
+
+	app.completeLogin(w, r, user)
+}
+
+// userForIdentity() returns the user linked to the identity, linking it to the account with
+// the same verified email address or provisioning a new account when there is none. The
+// identity provider decides who may log in, so the registration mode doesn't apply.
+func (app *application) userForIdentity(w http.ResponseWriter, r *http.Request, claims *oidc.Claims) (*data.User, bool) {
+	identity, err := app.models.Identities.Get(claims.Issuer, claims.Subject)
+	if err == nil {
+		user, err := app.models.Users.Get(identity.UserID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return nil, false
+		}
+		return user, true
+	}
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	v := validator.New()
+	if data.ValidateEmail(v, claims.Email); !v.Valid() {
+		app.identityEmailMissingResponse(w, r)
+		return nil, false
+	}
+
+	identity = &data.Identity{
+		Issuer:  claims.Issuer,
+		Subject: claims.Subject,
+		Email:   claims.Email,
+	}
+
+	user, err := app.models.Users.GetByEmail(claims.Email)
+	switch {
+	case err == nil:
+		// an unverified address could be claimed at the provider by someone else
+		if !claims.EmailVerified {
+			app.identityConflictResponse(w, r)
+			return nil, false
+		}
+
+		identity.UserID = user.ID
+		err = app.models.Identities.Insert(identity)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return nil, false
+		}
+
+		app.auditChange(r, "user.identity_link", "user", user.ID, nil, identity)
+
+		return user, true
+	case !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	user = &data.User{
+		Name:      claims.Name,
+		Email:     claims.Email,
+		Activated: claims.EmailVerified,
+	}
+	if strings.TrimSpace(user.Name) == "" {
+		user.Name, _, _ = strings.Cut(claims.Email, "@")
+	}
+
+	err = user.Password.SetRandom()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	err = app.models.Users.Insert(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			app.identityConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	err = app.models.Permissions.AddForUser(user.ID, "movies:read")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	identity.UserID = user.ID
+	err = app.models.Identities.Insert(identity)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	app.auditChange(r, "user.provision", "user", user.ID, nil, identity)
+
+	return user, true
+}
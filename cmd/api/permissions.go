@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/nhan10132020/greenlight/internal/data"
+	"github.com/nhan10132020/greenlight/internal/validator"
+)
+
+func (app *application) showUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	app.writeUserPermissions(w, r, user)
+}
+
+func (app *application) grantUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	app.changeUserPermissions(w, r, true)
+}
+
+func (app *application) revokeUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	app.changeUserPermissions(w, r, false)
+}
+
+// changeUserPermissions() grants or revokes individual permissions and roles of a user
+func (app *application) changeUserPermissions(w http.ResponseWriter, r *http.Request, grant bool) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Permissions []string `json:"permissions"`
+		Roles       []string `json:"roles"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if len(input.Permissions) == 0 && len(input.Roles) == 0 {
+		app.badRequestResponse(w, r, errors.New("request body must contain at least 1 permission or role"))
+		return
+	}
+
+	v := validator.New()
+
+	err = app.validatePermissionCodes(v, input.Permissions)
+	if err == nil {
+		err = app.validateRoleNames(v, input.Roles)
+	}
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if grant {
+		err = app.models.Permissions.AddForUser(user.ID, input.Permissions...)
+		if err == nil {
+			err = app.models.Roles.AddForUser(user.ID, input.Roles...)
+		}
+	} else {
+		err = app.models.Permissions.RemoveForUser(user.ID, input.Permissions...)
+		if err == nil {
+			err = app.models.Roles.RemoveForUser(user.ID, input.Roles...)
+		}
+	}
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	action := "user.permissions.revoke"
+	if grant {
+		action = "user.permissions.grant"
+	}
+	app.auditChange(r, action, "user", user.ID, nil, input)
+
+	app.writeUserPermissions(w, r, user)
+}
+
+// writeUserPermissions() writes the effective permissions of a user along with where they come from
+func (app *application) writeUserPermissions(w http.ResponseWriter, r *http.Request, user *data.User) {
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	direct, err := app.models.Permissions.GetDirectForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	roles, err := app.models.Roles.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{
+		"user_id":            user.ID,
+		"permissions":        permissions,
+		"direct_permissions": direct,
+		"roles":              roles,
+	}
+
+	err = app.writeJson(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readUserParam() loads the user named by the "id" URL parameter,
+// writing the error response itself when it can't
+func (app *application) readUserParam(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	user, err := app.models.Users.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return user, true
+}
+
+// validatePermissionCodes() checks that every code names an existing permission, the error
+// returned is the failure to load the permissions
+func (app *application) validatePermissionCodes(v *validator.Validator, codes []string) error {
+	known, err := app.models.Permissions.GetAll()
+	if err != nil {
+		return err
+	}
+
+	for _, code := range codes {
+		v.Check(known.Include(code), "permissions", fmt.Sprintf("contains unknown permission %q", code))
+	}
+
+	return nil
+}
+
+// validateRoleNames() checks that every name names an existing role, the error returned
+// is the failure to load the roles
+func (app *application) validateRoleNames(v *validator.Validator, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+
+	roles, err := app.models.Roles.GetAll()
+	if err != nil {
+		return err
+	}
+
+	known := make([]string, len(roles))
+	for i, role := range roles {
+		known[i] = role.Name
+	}
+
+	for _, name := range names {
+		v.Check(validator.In(name, known...), "roles", fmt.Sprintf("contains unknown role %q", name))
+	}
+
+	return nil
+}
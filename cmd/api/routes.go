@@ -20,11 +20,82 @@ func (app *application) routes() http.Handler {
 	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermission("movies:write", app.deleteMovieHandler))
 	router.HandlerFunc(http.MethodGet, "/v1/movies", app.requirePermission("movies:read", app.listMoviesHandler))
 
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/reviews", app.requirePermission("movies:read", app.createReviewHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/reviews", app.requirePermission("movies:read", app.listMovieReviewsHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/reviews/:id", app.requirePermission("movies:read", app.updateReviewHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/reviews/:id", app.requirePermission("movies:read", app.deleteReviewHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/people", app.requirePermission("movies:read", app.listPeopleHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/people", app.requirePermission("movies:write", app.createPersonHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/people/:id", app.requirePermission("movies:read", app.showPersonHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/people/:id", app.requirePermission("movies:write", app.updatePersonHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/people/:id", app.requirePermission("movies:write", app.deletePersonHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/credits", app.requirePermission("movies:read", app.listMovieCreditsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/credits", app.requirePermission("movies:write", app.createCreditHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/credits/:id", app.requirePermission("movies:write", app.deleteCreditHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/genres", app.requirePermission("movies:read", app.listGenresHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/genres", app.requirePermission("movies:write", app.createGenreHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/genres/:id", app.requirePermission("movies:write", app.updateGenreHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/genres/:id", app.requirePermission("movies:write", app.deleteGenreHandler))
+
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
 	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.updateUserPasswordHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/unlocked", app.unlockUserHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/users/me", app.requireAuthenticatedUser(app.showCurrentUserHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/me", app.requireSession(app.updateCurrentUserHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me", app.requireSession(app.deleteCurrentUserHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/export", app.requireSession(app.exportCurrentUserHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/me/email", app.requireSession(app.requestEmailChangeHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/email", app.confirmEmailChangeHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/email/cancelled", app.cancelEmailChangeHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/sessions", app.requireAuthenticatedUser(app.listUserSessionsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/watchlist", app.requirePermission("movies:read", app.listWatchlistHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/watchlist", app.requirePermission("movies:read", app.addWatchlistItemHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/watchlist", app.requirePermission("movies:read", app.reorderWatchlistHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/watchlist/:id", app.requirePermission("movies:read", app.removeWatchlistItemHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/watched", app.requirePermission("movies:read", app.listWatchedHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/watched", app.requirePermission("movies:read", app.logWatchedHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/watched/:id", app.requirePermission("movies:read", app.deleteWatchedHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/api-keys", app.requireSession(app.createAPIKeyHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/api-keys", app.requireSession(app.listAPIKeysHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/api-keys/:id", app.requireSession(app.deleteAPIKeyHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/2fa", app.requireSession(app.createTwoFactorHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/2fa", app.requireSession(app.confirmTwoFactorHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/admin/roles", app.requirePermission("permissions:admin", app.listRolesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/roles", app.requirePermission("permissions:admin", app.createRoleHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/roles/:id", app.requirePermission("permissions:admin", app.showRoleHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/admin/roles/:id", app.requirePermission("permissions:admin", app.updateRoleHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/roles/:id", app.requirePermission("permissions:admin", app.deleteRoleHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/audit", app.requirePermission("audit:read", app.listAuditEventsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/invites", app.requirePermission("users:admin", app.listInvitesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/invites", app.requirePermission("users:admin", app.createInviteHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/invites/:id", app.requirePermission("users:admin", app.deleteInviteHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users", app.requirePermission("users:admin", app.listUsersHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users/:id", app.requirePermission("users:admin", app.showUserHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/admin/users/:id/activated", app.requirePermission("users:admin", app.updateUserActivatedHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/tokens", app.requirePermission("users:admin", app.logoutUserHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/admin/users/:id/locked", app.requirePermission("users:admin", app.lockUserHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/locked", app.requirePermission("users:admin", app.unlockUserByAdminHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/impersonation", app.requirePermission("users:impersonate", app.createImpersonationTokenHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users/:id/permissions", app.requirePermission("permissions:admin", app.showUserPermissionsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/permissions", app.requirePermission("permissions:admin", app.grantUserPermissionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/permissions", app.requirePermission("permissions:admin", app.revokeUserPermissionsHandler))
 
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication", app.requireAuthenticatedUser(app.deleteAuthenticationTokenHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication/all", app.requireAuthenticatedUser(app.deleteAllAuthenticationTokensHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/2fa", app.createTwoFactorAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/magic-link", app.createMagicLinkTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/magic-link/exchange", app.exchangeMagicLinkTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/oidc", app.createOIDCAuthorizationHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/oidc/exchange", app.exchangeOIDCCodeHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/refresh", app.refreshAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", app.createActivationTokenHandler)
 
 	router.Handler(http.MethodGet, "/debug/vars", expvar.Handler())
-	return app.metrics(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router)))))
+	return app.metrics(app.requestID(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router))))))
 }
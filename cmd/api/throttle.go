@@ -0,0 +1,76 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// emailThrottle limits how many emails can be requested for a single address
+// within a sliding window. Attempts are counted per address whether or not an
+// account exists for it, so a throttled response doesn't leak account existence.
+type emailThrottle struct {
+	mu       sync.Mutex
+	limit    int
+	window   time.Duration
+	attempts map[string][]time.Time
+}
+
+func newEmailThrottle(limit int, window time.Duration) *emailThrottle {
+	t := &emailThrottle{
+		limit:    limit,
+		window:   window,
+		attempts: make(map[string][]time.Time),
+	}
+
+	// launch a background goroutine which removes addresses
+	// without any attempt inside the window once every minute
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+			t.mu.Lock()
+			for address := range t.attempts {
+				t.prune(address, time.Now())
+			}
+			t.mu.Unlock()
+		}
+	}()
+
+	return t
+}
+
+// Allow() records an attempt for the address and reports whether it is still
+// within the limit
+func (t *emailThrottle) Allow(email string) bool {
+	address := strings.ToLower(strings.TrimSpace(email))
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.prune(address, now)
+
+	if len(t.attempts[address]) >= t.limit {
+		return false
+	}
+
+	t.attempts[address] = append(t.attempts[address], now)
+	return true
+}
+
+// prune() drops the attempts which fell out of the window, must be called with the mutex held
+func (t *emailThrottle) prune(address string, now time.Time) {
+	attempts := t.attempts[address]
+
+	i := 0
+	for i < len(attempts) && now.Sub(attempts[i]) >= t.window {
+		i++
+	}
+
+	if i == len(attempts) {
+		delete(t.attempts, address)
+		return
+	}
+
+	t.attempts[address] = attempts[i:]
+}
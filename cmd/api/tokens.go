@@ -3,10 +3,13 @@ package main
 import (
 	"errors"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/nhan10132020/greenlight/internal/audit"
 	"github.com/nhan10132020/greenlight/internal/data"
 	"github.com/nhan10132020/greenlight/internal/validator"
+	"github.com/tomasen/realip"
 )
 
 func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
@@ -31,17 +34,34 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
+	blockedUntil, err := app.models.LoginFailures.BlockedUntil(realip.FromRequest(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if blockedUntil != nil {
+		app.loginBlockedResponse(w, r, *blockedUntil)
+		return
+	}
+
 	user, err := app.models.Users.GetByEmail(input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
-			app.invalidCredentialsResponse(w, r)
+			app.failedLoginResponse(w, r, nil)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
+	// a locked account is refused before the password is checked,
+	// so attempts during the lockout can't confirm a guessed password
+	if app.refuseLockedAccount(w, r, user) {
+		return
+	}
+
 	match, err := user.Password.Matches(input.Password)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -49,17 +69,509 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	}
 
 	if !match {
+		app.failedLoginResponse(w, r, user)
+		return
+	}
+
+	// transparently move the stored hash to the configured algorithm and cost,
+	// a failure leaves the old hash in place which still verifies
+	err = app.models.Users.UpgradePassword(user, input.Password)
+	if err != nil {
+		app.logError(r, err)
+	}
+
+	app.completeLogin(w, r, user)
+}
+
+// failedLoginResponse() counts a failed login against the client IP address and, when known,
+// the account, then responds with the lockout it triggered or invalid credentials
+func (app *application) failedLoginResponse(w http.ResponseWriter, r *http.Request, user *data.User) {
+	blockedUntil, lockedUntil, err := app.recordLoginFailure(r, user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	switch {
+	case lockedUntil != nil:
+		app.accountLockedResponse(w, r, *lockedUntil)
+	case blockedUntil != nil:
+		app.loginBlockedResponse(w, r, *blockedUntil)
+	default:
 		app.invalidCredentialsResponse(w, r)
+	}
+}
+
+// recordLoginFailure() counts a wrong password against the client IP address and, when known,
+// the account, returning the end of the IP block and of the account lockout if there is one
+func (app *application) recordLoginFailure(r *http.Request, user *data.User) (*time.Time, *time.Time, error) {
+	blockedUntil, err := app.models.LoginFailures.Record(realip.FromRequest(r), app.config.lockout.ip)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		failures    int
+		lockedUntil *time.Time
+	)
+	if user != nil {
+		failures, lockedUntil, err = app.models.Users.RecordLoginFailure(user.ID, app.config.lockout.account)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	event := audit.Event{Action: "auth.login_failed"}
+	if user != nil {
+		event.TargetType = "user"
+		event.TargetID = strconv.FormatInt(user.ID, 10)
+	}
+	app.audit(r, event)
+
+	// the unlock email is only sent when the lockout starts, not every time it is extended.
+	// The count comes from the increment itself, concurrent failures each see their own.
+	if lockedUntil != nil && failures == app.config.lockout.account.MaxAttempts {
+		app.sendUnlockEmail(r, user)
+	}
+
+	return blockedUntil, lockedUntil, nil
+}
+
+// refuseLockedAccount() responds with the lock of the account if there is one, reporting
+// whether it did so
+func (app *application) refuseLockedAccount(w http.ResponseWriter, r *http.Request, user *data.User) bool {
+	switch {
+	case user.IsAdminLocked():
+		app.accountAdminLockedResponse(w, r, *user.AdminLockedUntil)
+	case user.IsLocked():
+		app.accountLockedResponse(w, r, *user.LockedUntil)
+	default:
+		return false
+	}
+
+	return true
+}
+
+// confirmPassword() re-checks the password of the authenticated user before a sensitive change,
+// reporting whether it matched. A wrong password counts as a failed login so the re-check
+// can't be used to guess it past the lockout, and is reported as an error on the field.
+func (app *application) confirmPassword(w http.ResponseWriter, r *http.Request, user *data.User, field, password string) bool {
+	if app.refuseLockedAccount(w, r, user) {
+		return false
+	}
+
+	match, err := user.Password.Matches(password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+	if match {
+		return true
+	}
+
+	blockedUntil, lockedUntil, err := app.recordLoginFailure(r, user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	switch {
+	case lockedUntil != nil:
+		app.accountLockedResponse(w, r, *lockedUntil)
+	case blockedUntil != nil:
+		app.loginBlockedResponse(w, r, *blockedUntil)
+	default:
+		v := validator.New()
+		v.AddError(field, "is incorrect")
+		app.failedValidationResponse(w, r, v.Errors)
+	}
+
+	return false
+}
+
+// sendUnlockEmail() lets the owner of a locked account unlock it straight away
+func (app *application) sendUnlockEmail(r *http.Request, user *data.User) {
+	token, err := app.models.Tokens.New(user.ID, app.config.lockout.account.MaxDuration, data.ScopeUnlock)
+	if err != nil {
+		app.logError(r, err)
+		return
+	}
+
+	app.background(func() {
+		data := map[string]interface{}{
+			"unlockToken": token.Plaintext,
+		}
+
+		err := app.mailer.Send(user.Email, "account_locked.html", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+}
+
+// completeLogin() finishes a login once the first factor was verified: users with two-factor
+// authentication enabled get a short-lived pending token which must be exchanged together with
+// a code, everyone else gets their authentication tokens straight away
+func (app *application) completeLogin(w http.ResponseWriter, r *http.Request, user *data.User) {
+	if !user.TOTPEnabled {
+		app.issueAuthenticationTokens(w, r, user)
+		return
+	}
+
+	token, err := app.models.Tokens.NewForClient(user.ID, 5*time.Minute, data.ScopeTwoFactor, "", app.clientInfo(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{
+		"2fa_pending_token": token,
+		"message":           "two-factor authentication is required, send the token with a code to POST /v1/tokens/2fa",
+	}
+
+	err = app.writeJson(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// issueAuthenticationTokens() starts a new session for the user and writes its tokens, it must
+// only be called once every factor was verified
+func (app *application) issueAuthenticationTokens(w http.ResponseWriter, r *http.Request, user *data.User) {
+	// the failure counter is only reset by a complete login, resetting it after the password
+	// alone would let the second factor be guessed without ever triggering the lockout
+	if user.FailedLogins > 0 {
+		err := app.models.Users.ResetLoginFailures(user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	// logging in during the grace period of a deleted account takes the deletion back
+	if user.DeletedAt != nil {
+		err := app.models.Users.Restore(user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.auditUser(r, "user.restore", user, true)
+	}
+
+	refreshToken, err := app.models.Tokens.NewRefresh(user.ID, app.config.tokens.refreshTTL, app.clientInfo(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	authenticationToken, err := app.newAuthenticationToken(r, user, refreshToken.Family)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.auditUser(r, "auth.login", user, true)
+
+	err = app.writeJson(w, http.StatusCreated, envelope{"authentication_token": authenticationToken, "refresh_token": refreshToken}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createTwoFactorAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+		Code           string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidateTokenPlaintext(v, input.TokenPlaintext)
+	v.Check(input.Code != "", "code", "must be provided")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopeTwoFactor, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired two-factor token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// the pending token is single-use whatever the outcome, so a wrong code means logging in
+	// again with the password and codes can't be brute-forced within the token lifetime
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeTwoFactor, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// the account may have been locked by failed codes since the pending token was issued
+	if app.refuseLockedAccount(w, r, user) {
+		return
+	}
+
+	valid, err := app.verifySecondFactor(user, input.Code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !valid {
+		app.failedLoginResponse(w, r, user)
+		return
+	}
+
+	app.issueAuthenticationTokens(w, r, user)
+}
+
+func (app *application) refreshAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	refreshToken, err := app.models.Tokens.Rotate(input.TokenPlaintext, app.config.tokens.refreshTTL, app.clientInfo(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		case errors.Is(err, data.ErrTokenReused):
+			// an already rotated refresh token is only presented again if it was leaked,
+			// the whole token family has been revoked so the legitimate client must log in again
+			app.logger.PrintInfo("refresh token reuse detected, token family revoked", map[string]string{
+				"request_method": r.Method,
+				"request_url":    r.URL.String(),
+			})
+			app.audit(r, audit.Event{Action: "auth.refresh_token_reused"})
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
-	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	user, err := app.models.Users.Get(refreshToken.UserID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJson(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	if user.IsAdminLocked() {
+		app.accountAdminLockedResponse(w, r, *user.AdminLockedUntil)
+		return
+	}
+
+	authenticationToken, err := app.newAuthenticationToken(r, user, refreshToken.Family)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.auditUser(r, "auth.token_refresh", user, true)
+
+	err = app.writeJson(w, http.StatusCreated, envelope{"authentication_token": authenticationToken, "refresh_token": refreshToken}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// newAuthenticationToken() issues the access token of a refresh family: a token stored
+// in the database, or a signed token verified in-process when running in jwt auth mode
+func (app *application) newAuthenticationToken(r *http.Request, user *data.User, family string) (*data.Token, error) {
+	if app.config.auth.mode == authModeJWT {
+		return app.newJWT(user, family)
+	}
+
+	return app.models.Tokens.NewForClient(user.ID, app.config.tokens.authenticationTTL, data.ScopeAuthentication, family, app.clientInfo(r))
+}
+
+func (app *application) deleteAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var err error
+
+	// a JWT can't be revoked itself, revoking its refresh family stops it from being renewed
+	if claims := app.contextGetClaims(r); claims != nil {
+		err = app.models.Tokens.DeleteFamily(claims.Family)
+	} else if app.contextGetImpersonation(r) != nil {
+		err = app.models.Tokens.Delete(data.ScopeImpersonation, app.contextGetToken(r))
+	} else {
+		err = app.models.Tokens.Delete(data.ScopeAuthentication, app.contextGetToken(r))
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.auditUser(r, "auth.logout", app.contextGetUser(r), false)
+
+	err = app.writeJson(w, http.StatusOK, envelope{"message": "authentication token successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteAllAuthenticationTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	for _, scope := range []string{data.ScopeAuthentication, data.ScopeRefresh} {
+		err := app.models.Tokens.DeleteAllForUser(scope, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	app.auditUser(r, "auth.logout_all", user, false)
+
+	err := app.writeJson(w, http.StatusOK, envelope{"message": "all authentication tokens successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// the response is identical whether the address is unknown, not activated yet or an
+	// email was actually sent, so the endpoint can't be used to probe accounts
+	env := envelope{"message": "if an activated account exists for this email address, an email will be sent containing password reset instructions"}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if user != nil && user.Activated {
+		// password reset tokens are short-lived because they grant full control of the account
+		token, err := app.models.Tokens.New(user.ID, 45*time.Minute, data.ScopePasswordReset)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.auditUser(r, "auth.password_reset_requested", user, false)
+
+		app.background(func() {
+			data := map[string]interface{}{
+				"passwordResetToken": token.Plaintext,
+			}
+
+			err := app.mailer.Send(user.Email, "token_password_reset.html", data)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		})
+	}
+
+	err = app.writeJson(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// the throttle counts requests for the address itself, so being throttled
+	// says nothing about whether an account exists
+	if !app.emailThrottle.Allow(input.Email) {
+		app.rateLimitExceededResponse(w, r)
+		return
+	}
+
+	// the response is identical whether the address is unknown, already activated
+	// or an email was actually sent, so the endpoint can't be used to probe accounts
+	env := envelope{"message": "if an unactivated account exists for this email address, an email will be sent containing activation instructions"}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if user != nil && !user.Activated {
+		token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.background(func() {
+			data := map[string]interface{}{
+				"activationToken": token.Plaintext,
+			}
+
+			err = app.mailer.Send(user.Email, "token_activation.html", data)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		})
+	}
+
+	err = app.writeJson(w, http.StatusAccepted, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/nhan10132020/greenlight/internal/data"
+	"github.com/nhan10132020/greenlight/internal/totp"
+	"github.com/nhan10132020/greenlight/internal/validator"
+)
+
+func (app *application) createTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.models.Users.Get(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if user.TOTPEnabled {
+		v := validator.New()
+		v.AddError("2fa", "two-factor authentication is already enabled")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Users.SetTOTPSecret(user.ID, secret)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{
+		"otpauth_uri": totp.URI(secret, "Greenlight", user.Email),
+		"secret":      totp.EncodeSecret(secret),
+		"message":     "add the secret to your authenticator app then confirm with a code to PUT /v1/users/me/2fa",
+	}
+
+	err = app.writeJson(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) confirmTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(input.Code != "", "code", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.Get(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	switch {
+	case user.TOTPEnabled:
+		v.AddError("2fa", "two-factor authentication is already enabled")
+	case user.TOTPSecret == nil:
+		v.AddError("2fa", "two-factor enrollment must be started with POST /v1/users/me/2fa")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	valid, err := app.verifyTOTP(user, input.Code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !valid {
+		v.AddError("code", "invalid or expired code")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Users.EnableTOTP(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	codes, err := app.models.RecoveryCodes.Replace(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{
+		"recovery_codes": codes,
+		"message":        "two-factor authentication enabled, store the recovery codes somewhere safe as they won't be shown again",
+	}
+
+	app.auditUser(r, "user.2fa_enable", user, true)
+
+	err = app.writeJson(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// verifyTOTP() checks a code from the authenticator app, refusing codes already used
+func (app *application) verifyTOTP(user *data.User, code string) (bool, error) {
+	step, ok := totp.Validate(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	return app.models.Users.UseTOTPStep(user.ID, step)
+}
+
+// verifySecondFactor() accepts either a code from the authenticator app or one of the
+// user's unused recovery codes
+func (app *application) verifySecondFactor(user *data.User, code string) (bool, error) {
+	valid, err := app.verifyTOTP(user, code)
+	if err != nil || valid {
+		return valid, err
+	}
+
+	return app.models.RecoveryCodes.Use(user.ID, code)
+}
@@ -0,0 +1,196 @@
+// Package audit records privileged and security-relevant actions in the append-only
+// audit_events table, so that it can later be told who did what to which record.
+package audit
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/nhan10132020/greenlight/internal/data"
+	"gorm.io/gorm"
+)
+
+// JSON is a JSON document stored in a jsonb column, nil is stored as NULL
+type JSON json.RawMessage
+
+func (j JSON) Value() (driver.Value, error) {
+	if j == nil {
+		return nil, nil
+	}
+	return string(j), nil
+}
+
+func (j *JSON) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case nil:
+		*j = nil
+	case string:
+		*j = JSON(src)
+	case []byte:
+		*j = append(JSON(nil), src...)
+	default:
+		return fmt.Errorf("audit: can't scan %T into JSON", src)
+	}
+	return nil
+}
+
+func (j JSON) MarshalJSON() ([]byte, error) {
+	if j == nil {
+		return []byte("null"), nil
+	}
+	return j, nil
+}
+
+// Event is one recorded action
+type Event struct {
+	ID             int64     `json:"id" gorm:"column:id"`
+	CreatedAt      time.Time `json:"created_at" gorm:"column:created_at"`
+	ActorID        *int64    `json:"actor_id" gorm:"column:actor_id"`               // user who acted, nil for anonymous requests
+	ImpersonatorID *int64    `json:"impersonator_id" gorm:"column:impersonator_id"` // staff member acting as the actor, if any
+	Action         string    `json:"action" gorm:"column:action"`                   // dotted name such as "movie.delete"
+	TargetType     string    `json:"target_type" gorm:"column:target_type"`
+	TargetID       string    `json:"target_id" gorm:"column:target_id"`
+	Before         JSON      `json:"before" gorm:"column:before"` // fields of the target which changed, as they were
+	After          JSON      `json:"after" gorm:"column:after"`   // the same fields as they became
+	IP             string    `json:"ip" gorm:"column:ip"`
+	RequestID      string    `json:"request_id" gorm:"column:request_id"`
+}
+
+func (Event) TableName() string { return "audit_events" }
+
+// Diff() returns the JSON fields which differ between two versions of a record. Either side
+// may be nil, for a record being created or deleted, in which case all fields of the other
+// side are returned.
+func Diff(before, after interface{}) (JSON, JSON, error) {
+	b, err := fields(before)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	a, err := fields(after)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if b != nil && a != nil {
+		for key := range b {
+			if reflect.DeepEqual(b[key], a[key]) {
+				delete(b, key)
+				delete(a, key)
+			}
+		}
+	}
+
+	bj, err := document(b)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	aj, err := document(a)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return bj, aj, nil
+}
+
+func fields(v interface{}) (map[string]interface{}, error) {
+	if v == nil || reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil() {
+		return nil, nil
+	}
+
+	js, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var m map[string]interface{}
+	err = json.Unmarshal(js, &m)
+	return m, err
+}
+
+func document(m map[string]interface{}) (JSON, error) {
+	if m == nil {
+		return nil, nil
+	}
+
+	js, err := json.Marshal(m)
+	return JSON(js), err
+}
+
+// Query holds the optional filters of Log.GetAll(), zero values don't filter
+type Query struct {
+	ActorID    *int64
+	Action     string // exact action, or a prefix ending with "." such as "movie."
+	TargetType string
+	TargetID   string
+	RequestID  string
+	Since      *time.Time
+	Until      *time.Time
+}
+
+type Log struct {
+	DB *gorm.DB
+}
+
+func (l Log) Insert(event *Event) error {
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return l.DB.WithContext(ctx).Omit("ID", "CreatedAt").Create(event).Error
+}
+
+// GetAll() returns the events matching the query, the newest first
+func (l Log) GetAll(query Query, filters data.Filters) ([]*Event, data.Metadata, error) {
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	events := []*Event{}
+	var totalRecords int64
+
+	db := l.DB.WithContext(ctx).Model(&Event{})
+
+	if query.ActorID != nil {
+		db = db.Where("actor_id = ?", *query.ActorID)
+	}
+	if query.Action != "" {
+		if query.Action[len(query.Action)-1] == '.' {
+			db = db.Where("action LIKE ? || '%'", data.EscapeLike(query.Action))
+		} else {
+			db = db.Where("action = ?", query.Action)
+		}
+	}
+	if query.TargetType != "" {
+		db = db.Where("target_type = ?", query.TargetType)
+	}
+	if query.TargetID != "" {
+		db = db.Where("target_id = ?", query.TargetID)
+	}
+	if query.RequestID != "" {
+		db = db.Where("request_id = ?", query.RequestID)
+	}
+	if query.Since != nil {
+		db = db.Where("created_at >= ?", *query.Since)
+	}
+	if query.Until != nil {
+		db = db.Where("created_at < ?", *query.Until)
+	}
+
+	if err := db.
+		Count(&totalRecords).
+		Order("id DESC").
+		Limit(filters.PageSize).
+		Offset((filters.Page - 1) * filters.PageSize).
+		Find(&events).
+		Error; err != nil {
+		return nil, data.Metadata{}, err
+	}
+
+	return events, data.CalculateMetadata(int(totalRecords), filters.Page, filters.PageSize), nil
+}
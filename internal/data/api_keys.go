@@ -0,0 +1,170 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/nhan10132020/greenlight/internal/validator"
+	"gorm.io/gorm"
+)
+
+type APIKey struct {
+	ID          int64          `json:"id" gorm:"column:id"`
+	UserID      int64          `json:"-" gorm:"column:user_id"`
+	Name        string         `json:"name" gorm:"column:name"`
+	Plaintext   string         `json:"key,omitempty" gorm:"-"`                            // only returned once, when the key is created
+	Prefix      string         `json:"prefix" gorm:"column:prefix"`                       // first characters of the key to tell keys apart
+	Hash        []byte         `json:"-" gorm:"column:hash"`                              // SHA-256 hash of the key
+	Permissions pq.StringArray `json:"permissions" gorm:"column:permissions;type:text[]"` // restricts the key to these codes, nil for all of the user's permissions
+	CreatedAt   time.Time      `json:"created_at" gorm:"column:created_at"`
+	LastUsedAt  *time.Time     `json:"last_used_at" gorm:"column:last_used_at"`
+	Expiry      *time.Time     `json:"expiry" gorm:"column:expiry"` // nil for a key which never expires
+}
+
+func (APIKey) TableName() string { return "api_keys" }
+
+// Restrict() narrows the permissions of the key owner down to the codes the key was created for
+func (k *APIKey) Restrict(permissions Permissions) Permissions {
+	if k.Permissions == nil {
+		return permissions
+	}
+
+	restricted := Permissions{}
+	for _, code := range permissions {
+		if validator.In(code, k.Permissions...) {
+			restricted = append(restricted, code)
+		}
+	}
+
+	return restricted
+}
+
+func ValidateAPIKey(v *validator.Validator, key *APIKey) {
+	v.Check(key.Name != "", "name", "must be provided")
+	v.Check(len(key.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(validator.Unique(key.Permissions), "permissions", "must not contain duplicate values")
+	if key.Expiry != nil {
+		v.Check(key.Expiry.After(time.Now()), "expiry", "must be in the future")
+	}
+}
+
+// Check that the plaintext API key has been provided and is exactly
+// 52 bytes long
+func ValidateAPIKeyPlaintext(v *validator.Validator, keyPlaintext string) {
+	v.Check(keyPlaintext != "", "key", "must be provided")
+	v.Check(len(keyPlaintext) == 52, "key", "must be 52 bytes long")
+}
+
+type APIKeyModel struct {
+	DB *gorm.DB
+}
+
+// Insert() generates the secret of the key and stores its hash
+func (m APIKeyModel) Insert(key *APIKey) error {
+	// 256 bits (32 bytes) of entropy, API keys live much longer than tokens
+	randomBytes := make([]byte, 32)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return err
+	}
+
+	// the length of plaintext is 52 due to base-32 string encoded of 32 bytes
+	key.Plaintext = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	key.Prefix = key.Plaintext[:8]
+
+	hash := sha256.Sum256([]byte(key.Plaintext))
+	key.Hash = hash[:]
+
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.WithContext(ctx).Omit("ID", "CreatedAt", "LastUsedAt").Create(key).Error
+}
+
+func (m APIKeyModel) GetAllForUser(userID int64) ([]*APIKey, error) {
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	keys := []*APIKey{}
+
+	if err := m.DB.WithContext(ctx).Where("user_id = ?", userID).Order("id ASC").Find(&keys).Error; err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func (m APIKeyModel) Delete(id, userID int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result := m.DB.WithContext(ctx).Where("id = ? AND user_id = ?", id, userID).Delete(&APIKey{})
+
+	if err := result.Error; err != nil {
+		return err
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// DeleteAllForUser() revokes every key of the user
+func (m APIKeyModel) DeleteAllForUser(userID int64) error {
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.WithContext(ctx).Where("user_id = ?", userID).Delete(&APIKey{}).Error
+}
+
+// GetForKey() returns an unexpired key matching the plaintext, and records that it was used
+func (m APIKeyModel) GetForKey(keyPlaintext string) (*APIKey, error) {
+	// calculate the SHA-256 hash of the plaintext key provided by the client
+	keyHash := sha256.Sum256([]byte(keyPlaintext))
+
+	var key APIKey
+
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := m.DB.
+		WithContext(ctx).
+		Where("hash = ? AND (expiry IS NULL OR expiry > ?)", keyHash[:], time.Now()).
+		First(&key).
+		Error; err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	// like tokens, only refresh the last use timestamp once a minute
+	if key.LastUsedAt == nil || time.Since(*key.LastUsedAt) > time.Minute {
+		now := time.Now()
+		if err := m.DB.WithContext(ctx).Model(&key).Update("last_used_at", now).Error; err != nil {
+			return nil, err
+		}
+		key.LastUsedAt = &now
+	}
+
+	return &key, nil
+}
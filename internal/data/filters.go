@@ -58,7 +58,7 @@ type Metadata struct {
 	TotalRecords int `json:"total_records,omitempty"`
 }
 
-func calculateMetadata(totalRecords, page, pageSize int) Metadata {
+func CalculateMetadata(totalRecords, page, pageSize int) Metadata {
 	if totalRecords == 0 || totalRecords < pageSize*(page-1) {
 		return Metadata{}
 	}
@@ -71,3 +71,11 @@ func calculateMetadata(totalRecords, page, pageSize int) Metadata {
 		TotalRecords: totalRecords,
 	}
 }
+
+// likeEscaper escapes the wildcards of a LIKE pattern with backslash, the default escape character
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// EscapeLike() makes a value match only itself when used in a LIKE pattern
+func EscapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
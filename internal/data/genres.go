@@ -0,0 +1,273 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lib/pq"
+	"github.com/nhan10132020/greenlight/internal/validator"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrDuplicateGenre = errors.New("duplicate genre")
+	ErrGenreInUse     = errors.New("genre in use")
+)
+
+// Genre is an entry of the managed list of genres movies can have. Movies store the slug.
+type Genre struct {
+	ID         int64          `json:"id" gorm:"column:id"`
+	CreatedAt  time.Time      `json:"-" gorm:"column:created_at"`
+	Slug       string         `json:"slug" gorm:"column:slug"`                              // normalized identifier, can't be changed
+	Name       string         `json:"name" gorm:"column:name"`                              // display name
+	Aliases    pq.StringArray `json:"aliases" gorm:"column:aliases;type:text[]"`            // other names resolving to the genre, normalized like slugs
+	Version    int32          `json:"version" gorm:"column:version"`                        // The version number starts at 1 and increment when genre information updated
+	MovieCount int64          `json:"movie_count" gorm:"column:movie_count;->;-:migration"` // only filled in by GenreModel.GetAll()
+}
+
+func (Genre) TableName() string { return "genres" }
+
+var genreSlugRX = regexp.MustCompile("[^a-z0-9]+")
+
+// GenreSlug() normalizes a genre name, so that "Sci-Fi", "sci fi" and "sci-fi" are the same.
+// The genre_slug() database function must normalize the same way.
+func GenreSlug(name string) string {
+	return strings.Trim(genreSlugRX.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
+
+// GenreTaxonomy maps the slug and every alias of the genres to the genre slug
+type GenreTaxonomy map[string]string
+
+func NewGenreTaxonomy(genres []*Genre) GenreTaxonomy {
+	t := make(GenreTaxonomy)
+
+	for _, genre := range genres {
+		t[genre.Slug] = genre.Slug
+		for _, alias := range genre.Aliases {
+			t[alias] = genre.Slug
+		}
+	}
+
+	return t
+}
+
+// Resolve() returns the slug of the genre known by the name
+func (t GenreTaxonomy) Resolve(name string) (string, bool) {
+	slug, ok := t[GenreSlug(name)]
+	return slug, ok
+}
+
+// ResolveAll() replaces the names of known genres by their slugs, unknown names are kept as
+// they are for validation to report
+func (t GenreTaxonomy) ResolveAll(names []string) []string {
+	if names == nil {
+		return nil
+	}
+
+	resolved := make([]string, len(names))
+
+	for i, name := range names {
+		if slug, ok := t.Resolve(name); ok {
+			resolved[i] = slug
+		} else {
+			resolved[i] = name
+		}
+	}
+
+	return resolved
+}
+
+// Contains() reports whether the value is the slug of a genre, not an alias or another name
+func (t GenreTaxonomy) Contains(slug string) bool {
+	s, ok := t[slug]
+	return ok && s == slug
+}
+
+// NormalizeGenreAliases() turns the aliases into the form they are stored and looked up in
+func NormalizeGenreAliases(aliases []string) pq.StringArray {
+	normalized := make(pq.StringArray, len(aliases))
+
+	for i, alias := range aliases {
+		normalized[i] = GenreSlug(alias)
+	}
+
+	return normalized
+}
+
+// ValidateGenre() checks the genre against the taxonomy of the other genres, its slug and
+// aliases must not resolve to another genre
+func ValidateGenre(v *validator.Validator, genre *Genre, taxonomy GenreTaxonomy) {
+	v.Check(genre.Slug != "", "slug", "must be provided")
+	v.Check(len(genre.Slug) <= 100, "slug", "must not be more than 100 bytes long")
+	v.Check(genre.Slug == GenreSlug(genre.Slug), "slug", "must only contain lowercase letters, digits and single dashes")
+	v.Check(genre.Name != "", "name", "must be provided")
+	v.Check(len(genre.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(len(genre.Aliases) <= 20, "aliases", "must not contain more than 20 aliases")
+
+	if owner, ok := taxonomy[genre.Slug]; ok && owner != genre.Slug {
+		v.AddError("slug", fmt.Sprintf("is already an alias of %q", owner))
+	}
+
+	for _, alias := range genre.Aliases {
+		v.Check(alias != "", "aliases", "must not contain empty values")
+		v.Check(alias == GenreSlug(alias), "aliases", "must only contain lowercase letters, digits and single dashes")
+		v.Check(alias != genre.Slug, "aliases", "must not contain the slug")
+		if owner, ok := taxonomy[alias]; ok && owner != genre.Slug {
+			v.AddError("aliases", fmt.Sprintf("%q already belongs to %q", alias, owner))
+		}
+	}
+
+	v.Check(validator.Unique(genre.Aliases), "aliases", "must not contain duplicate values")
+}
+
+type GenreModel struct {
+	DB *gorm.DB
+}
+
+func (m GenreModel) Insert(genre *Genre) error {
+	if genre.Aliases == nil {
+		genre.Aliases = pq.StringArray{}
+	}
+
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := m.DB.WithContext(ctx).Omit("ID", "CreatedAt", "Version").Create(genre).Error; err != nil {
+		var perr *pgconn.PgError
+		if errors.As(err, &perr) {
+			if perr.Code == "23505" && strings.Contains(perr.Message, "genres_slug_key") {
+				return ErrDuplicateGenre
+			}
+		}
+		return err
+	}
+	genre.Version = 1
+	return nil
+}
+
+func (m GenreModel) Get(id int64) (*Genre, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	var genre Genre
+
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := m.DB.WithContext(ctx).Where("id = ?", id).First(&genre).Error; err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &genre, nil
+}
+
+// GetAll() returns every genre with the number of movies having it, ordered by name
+func (m GenreModel) GetAll() ([]*Genre, error) {
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	genres := []*Genre{}
+
+	if err := m.DB.
+		WithContext(ctx).
+		Select("genres.*, (SELECT count(*) FROM movies WHERE movies.genres @> ARRAY[genres.slug]) AS movie_count").
+		Order("name ASC, id ASC").
+		Find(&genres).
+		Error; err != nil {
+		return nil, err
+	}
+
+	return genres, nil
+}
+
+// Taxonomy() loads the genres for resolving names to slugs
+func (m GenreModel) Taxonomy() (GenreTaxonomy, error) {
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	genres := []*Genre{}
+
+	if err := m.DB.WithContext(ctx).Select("slug", "aliases").Find(&genres).Error; err != nil {
+		return nil, err
+	}
+
+	return NewGenreTaxonomy(genres), nil
+}
+
+func (m GenreModel) Update(genre *Genre) error {
+	genre.Version += 1
+
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// at condition on "version" field to avoid data race existing, the fields are
+	// selected explicitly as the aliases may be cleared
+	result := m.DB.
+		WithContext(ctx).
+		Model(genre).
+		Where("version = ?", genre.Version-1).
+		Select("Name", "Aliases", "Version").
+		Updates(genre)
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrEditConflict
+	}
+	return nil
+}
+
+// Delete() removes a genre no movie has
+func (m GenreModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var genre Genre
+
+		if err := tx.
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ?", id).
+			First(&genre).
+			Error; err != nil {
+			switch {
+			case errors.Is(err, gorm.ErrRecordNotFound):
+				return ErrRecordNotFound
+			default:
+				return err
+			}
+		}
+
+		var movies int64
+		if err := tx.Model(&Movie{}).Where("genres @> ?", pq.StringArray{genre.Slug}).Count(&movies).Error; err != nil {
+			return err
+		}
+		if movies > 0 {
+			return ErrGenreInUse
+		}
+
+		return tx.Delete(&Genre{}, id).Error
+	})
+}
@@ -0,0 +1,161 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrDuplicateIdentity = errors.New("duplicate identity")
+)
+
+// Identity links a user to their account at an OpenID Connect identity provider
+type Identity struct {
+	ID        int64     `json:"id" gorm:"column:id"`
+	CreatedAt time.Time `json:"created_at" gorm:"column:created_at"`
+	UserID    int64     `json:"-" gorm:"column:user_id"`
+	Issuer    string    `json:"issuer" gorm:"column:issuer"`
+	Subject   string    `json:"subject" gorm:"column:subject"` // identifier of the user at the issuer, never reassigned
+	Email     string    `json:"email" gorm:"column:email"`     // address the issuer reported when the identity was linked
+}
+
+func (Identity) TableName() string { return "user_identities" }
+
+type IdentityModel struct {
+	DB *gorm.DB
+}
+
+func (m IdentityModel) Insert(identity *Identity) error {
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := m.DB.WithContext(ctx).Omit("ID", "CreatedAt").Create(identity).Error; err != nil {
+		var perr *pgconn.PgError
+		if errors.As(err, &perr) {
+			if perr.Code == "23505" && strings.Contains(perr.Message, "user_identities_issuer_subject_key") {
+				return ErrDuplicateIdentity
+			}
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Get() returns the identity of the subject at the issuer
+func (m IdentityModel) Get(issuer, subject string) (*Identity, error) {
+	var identity Identity
+
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := m.DB.WithContext(ctx).Where("issuer = ? AND subject = ?", issuer, subject).First(&identity).Error; err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &identity, nil
+}
+
+func (m IdentityModel) GetAllForUser(userID int64) ([]*Identity, error) {
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	identities := []*Identity{}
+
+	if err := m.DB.WithContext(ctx).Where("user_id = ?", userID).Order("id ASC").Find(&identities).Error; err != nil {
+		return nil, err
+	}
+
+	return identities, nil
+}
+
+// OIDCState is a login started at the identity provider. The plaintext is sent along as the
+// OAuth state parameter and identifies the login when the authorization code comes back.
+type OIDCState struct {
+	Plaintext    string    `gorm:"-"`
+	Hash         []byte    `gorm:"column:hash"`
+	CodeVerifier string    `gorm:"column:code_verifier"` // PKCE verifier, never leaves the server
+	Nonce        string    `gorm:"column:nonce"`         // must come back in the ID token
+	Expiry       time.Time `gorm:"column:expiry"`
+}
+
+func (OIDCState) TableName() string { return "oidc_states" }
+
+type OIDCStateModel struct {
+	DB *gorm.DB
+}
+
+// New() stores a login with a fresh state, expired logins nobody came back from are
+// cleared on the way
+func (m OIDCStateModel) New(ttl time.Duration, codeVerifier, nonce string) (*OIDCState, error) {
+	plaintext, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.Sum256([]byte(plaintext))
+
+	state := &OIDCState{
+		Plaintext:    plaintext,
+		Hash:         hash[:],
+		CodeVerifier: codeVerifier,
+		Nonce:        nonce,
+		Expiry:       time.Now().Add(ttl),
+	}
+
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = m.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("expiry < ?", time.Now()).Delete(&OIDCState{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(state).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+// Consume() removes and returns the unexpired login with the state, so that it can only be
+// completed once
+func (m OIDCStateModel) Consume(plaintext string) (*OIDCState, error) {
+	hash := sha256.Sum256([]byte(plaintext))
+
+	var states []*OIDCState
+
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := m.DB.
+		WithContext(ctx).
+		Raw("DELETE FROM oidc_states WHERE hash = ? AND expiry > ? RETURNING *", hash[:], time.Now()).
+		Scan(&states).
+		Error; err != nil {
+		return nil, err
+	}
+
+	if len(states) == 0 {
+		return nil, ErrRecordNotFound
+	}
+
+	return states[0], nil
+}
@@ -0,0 +1,166 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/nhan10132020/greenlight/internal/validator"
+	"gorm.io/gorm"
+)
+
+// Invite lets people register while registration is invite-only
+type Invite struct {
+	ID          int64          `json:"id" gorm:"column:id"`
+	CreatedAt   time.Time      `json:"created_at" gorm:"column:created_at"`
+	CreatedBy   *int64         `json:"created_by" gorm:"column:created_by"`               // nil once the admin who created it is deleted
+	Plaintext   string         `json:"code,omitempty" gorm:"-"`                           // only returned once, when the invite is created
+	Hash        []byte         `json:"-" gorm:"column:hash"`                              // SHA-256 hash of the code
+	Email       *string        `json:"email" gorm:"column:email"`                         // only this address may use the invite, nil for any
+	Expiry      time.Time      `json:"expiry" gorm:"column:expiry"`                       // the invite can't be used afterwards
+	MaxUses     int            `json:"max_uses" gorm:"column:max_uses"`                   // number of accounts the invite may create
+	Uses        int            `json:"uses" gorm:"column:uses"`                           // number of accounts created with it so far
+	Permissions pq.StringArray `json:"permissions" gorm:"column:permissions;type:text[]"` // granted to accounts created with the invite
+}
+
+func (Invite) TableName() string { return "invites" }
+
+// BindsEmail() reports whether the invite was sent to this address, which proves the address
+// belongs to whoever registers with it
+func (i *Invite) BindsEmail(email string) bool {
+	return i.Email != nil && strings.EqualFold(*i.Email, email)
+}
+
+func ValidateInvite(v *validator.Validator, invite *Invite) {
+	if invite.Email != nil {
+		ValidateEmail(v, *invite.Email)
+	}
+	v.Check(invite.Expiry.After(time.Now()), "expiry", "must be in the future")
+	v.Check(invite.MaxUses > 0, "max_uses", "must be greater than zero")
+	v.Check(invite.MaxUses <= 1000, "max_uses", "must not be more than 1000")
+	v.Check(validator.Unique(invite.Permissions), "permissions", "must not contain duplicate values")
+}
+
+// Check that the plaintext invite code has been provided and is exactly
+// 26 bytes long
+func ValidateInviteCode(v *validator.Validator, code string) {
+	v.Check(code != "", "invite_code", "must be provided")
+	v.Check(len(code) == 26, "invite_code", "must be 26 bytes long")
+}
+
+type InviteModel struct {
+	DB *gorm.DB
+}
+
+// Insert() generates the code of the invite and stores its hash
+func (m InviteModel) Insert(invite *Invite) error {
+	randomBytes := make([]byte, 16)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return err
+	}
+
+	// the length of plaintext is 26 due to base-32 string encoded of 16 bytes, like tokens
+	invite.Plaintext = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+
+	hash := sha256.Sum256([]byte(invite.Plaintext))
+	invite.Hash = hash[:]
+
+	if invite.Permissions == nil {
+		invite.Permissions = pq.StringArray{}
+	}
+
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.WithContext(ctx).Omit("ID", "CreatedAt", "Uses").Create(invite).Error
+}
+
+func (m InviteModel) GetAll() ([]*Invite, error) {
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	invites := []*Invite{}
+
+	if err := m.DB.WithContext(ctx).Order("id ASC").Find(&invites).Error; err != nil {
+		return nil, err
+	}
+
+	return invites, nil
+}
+
+func (m InviteModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result := m.DB.WithContext(ctx).Delete(&Invite{}, id)
+
+	if err := result.Error; err != nil {
+		return err
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Redeem() uses up one registration of the invite matching the code, provided it is
+// unexpired, has registrations left and is either unbound or bound to the email address
+func (m InviteModel) Redeem(code, email string) (*Invite, error) {
+	hash := sha256.Sum256([]byte(code))
+
+	var invites []*Invite
+
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := m.DB.
+		WithContext(ctx).
+		Raw(`UPDATE invites SET uses = uses + 1
+			WHERE hash = ? AND uses < max_uses AND expiry > ? AND (email IS NULL OR email = ?)
+			RETURNING *`, hash[:], time.Now(), email).
+		Scan(&invites).
+		Error; err != nil {
+		return nil, err
+	}
+
+	if len(invites) == 0 {
+		return nil, ErrRecordNotFound
+	}
+
+	return invites[0], nil
+}
+
+// Release() gives back a registration taken by Redeem() when the account couldn't be created
+func (m InviteModel) Release(invite *Invite) error {
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := m.DB.
+		WithContext(ctx).
+		Model(&Invite{}).
+		Where("id = ? AND uses > 0", invite.ID).
+		Update("uses", gorm.Expr("uses - 1")).
+		Error; err != nil {
+		return err
+	}
+
+	invite.Uses--
+	return nil
+}
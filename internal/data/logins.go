@@ -0,0 +1,156 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// LockoutPolicy describes how repeated login failures are throttled. Once MaxAttempts
+// consecutive failures are reached every further failure doubles the lockout, starting
+// at Duration and capped at MaxDuration.
+type LockoutPolicy struct {
+	MaxAttempts int
+	Duration    time.Duration
+	MaxDuration time.Duration
+}
+
+// backoff() returns how long to lock after the given number of consecutive failures
+func (p LockoutPolicy) backoff(failures int) time.Duration {
+	if p.MaxAttempts < 1 || failures < p.MaxAttempts {
+		return 0
+	}
+
+	d := p.Duration
+	for i := p.MaxAttempts; i < failures && d < p.MaxDuration; i++ {
+		d *= 2
+	}
+
+	if d > p.MaxDuration {
+		d = p.MaxDuration
+	}
+
+	return d
+}
+
+// RecordLoginFailure() counts a failed login against the account, locking it when the policy
+// says so. It returns the number of consecutive failures including this one, and the end of the
+// lockout when this failure locked the account.
+func (m UserModel) RecordLoginFailure(userID int64, policy LockoutPolicy) (int, *time.Time, error) {
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var failures int
+
+	// increment in the database so concurrent attempts are all counted
+	if err := m.DB.
+		WithContext(ctx).
+		Raw("UPDATE users SET failed_logins = failed_logins + 1 WHERE id = ? RETURNING failed_logins", userID).
+		Scan(&failures).
+		Error; err != nil {
+		return 0, nil, err
+	}
+
+	d := policy.backoff(failures)
+	if d == 0 {
+		return failures, nil, nil
+	}
+
+	lockedUntil := time.Now().Add(d)
+
+	if err := m.DB.WithContext(ctx).Model(&User{}).Where("id = ?", userID).Update("locked_until", lockedUntil).Error; err != nil {
+		return 0, nil, err
+	}
+
+	return failures, &lockedUntil, nil
+}
+
+// ResetLoginFailures() clears the failure counter and any lockout of the account
+func (m UserModel) ResetLoginFailures(userID int64) error {
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.
+		WithContext(ctx).
+		Model(&User{}).
+		Where("id = ?", userID).
+		Updates(map[string]interface{}{"failed_logins": 0, "locked_until": nil}).
+		Error
+}
+
+// LoginFailure tracks the failed logins coming from one IP address, across all accounts
+type LoginFailure struct {
+	IP            string     `gorm:"column:ip"`
+	Failures      int        `gorm:"column:failures"`
+	LastFailureAt time.Time  `gorm:"column:last_failure_at"`
+	BlockedUntil  *time.Time `gorm:"column:blocked_until"`
+}
+
+func (LoginFailure) TableName() string { return "login_failures" }
+
+type LoginFailureModel struct {
+	DB *gorm.DB
+}
+
+// BlockedUntil() returns the end of the block on an IP address, or nil if it isn't blocked
+func (m LoginFailureModel) BlockedUntil(ip string) (*time.Time, error) {
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var failure LoginFailure
+
+	if err := m.DB.WithContext(ctx).Where("ip = ?", ip).First(&failure).Error; err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return nil, nil
+		default:
+			return nil, err
+		}
+	}
+
+	if failure.BlockedUntil == nil || !failure.BlockedUntil.After(time.Now()) {
+		return nil, nil
+	}
+
+	return failure.BlockedUntil, nil
+}
+
+// Record() counts a failed login from an IP address and blocks it when the policy says so.
+// The count starts over once the address has been quiet for longer than the maximum lockout.
+func (m LoginFailureModel) Record(ip string, policy LockoutPolicy) (*time.Time, error) {
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var failures int
+
+	if err := m.DB.
+		WithContext(ctx).
+		Raw(`INSERT INTO login_failures (ip, failures, last_failure_at) VALUES (?, 1, NOW())
+			ON CONFLICT (ip) DO UPDATE SET
+				failures = CASE WHEN login_failures.last_failure_at < ? THEN 1 ELSE login_failures.failures + 1 END,
+				last_failure_at = NOW()
+			RETURNING failures`, ip, time.Now().Add(-policy.MaxDuration)).
+		Scan(&failures).
+		Error; err != nil {
+		return nil, err
+	}
+
+	d := policy.backoff(failures)
+	if d == 0 {
+		return nil, nil
+	}
+
+	blockedUntil := time.Now().Add(d)
+
+	if err := m.DB.WithContext(ctx).Model(&LoginFailure{}).Where("ip = ?", ip).Update("blocked_until", blockedUntil).Error; err != nil {
+		return nil, err
+	}
+
+	return &blockedUntil, nil
+}
@@ -12,13 +12,27 @@ var (
 )
 
 type Models struct {
-	Movies      MovieModel
-	Users       UserModel
-	Tokens      TokenModel
-	Permissions PermissionsModel
+	Movies        MovieModel
+	Users         UserModel
+	Tokens        TokenModel
+	Permissions   PermissionsModel
+	APIKeys       APIKeyModel
+	RecoveryCodes RecoveryCodeModel
+	LoginFailures LoginFailureModel
+	Roles         RoleModel
+	Invites       InviteModel
+	Identities    IdentityModel
+	OIDCStates    OIDCStateModel
+	Reviews       ReviewModel
+	Watchlists    WatchlistModel
+	Viewings      ViewingModel
+	People        PersonModel
+	Credits       CreditModel
+	Genres        GenreModel
 }
 
-func NewModels(db *gorm.DB) Models {
+// NewModels() wires the models to the database, permissionCache may be nil to disable caching
+func NewModels(db *gorm.DB, permissionCache *PermissionCache) Models {
 	return Models{
 		Movies: MovieModel{
 			DB: db,
@@ -30,6 +44,47 @@ func NewModels(db *gorm.DB) Models {
 			DB: db,
 		},
 		Permissions: PermissionsModel{
+			DB:    db,
+			Cache: permissionCache,
+		},
+		APIKeys: APIKeyModel{
+			DB: db,
+		},
+		RecoveryCodes: RecoveryCodeModel{
+			DB: db,
+		},
+		LoginFailures: LoginFailureModel{
+			DB: db,
+		},
+		Roles: RoleModel{
+			DB:    db,
+			Cache: permissionCache,
+		},
+		Invites: InviteModel{
+			DB: db,
+		},
+		Identities: IdentityModel{
+			DB: db,
+		},
+		OIDCStates: OIDCStateModel{
+			DB: db,
+		},
+		Reviews: ReviewModel{
+			DB: db,
+		},
+		Watchlists: WatchlistModel{
+			DB: db,
+		},
+		Viewings: ViewingModel{
+			DB: db,
+		},
+		People: PersonModel{
+			DB: db,
+		},
+		Credits: CreditModel{
+			DB: db,
+		},
+		Genres: GenreModel{
 			DB: db,
 		},
 	}
@@ -19,10 +19,15 @@ type Movie struct {
 	Runtime   Runtime        `json:"runtime,omitempty" gorm:"column:runtime"`           // Movie runtime(in minutes)
 	Genres    pq.StringArray `json:"genres,omitempty" gorm:"column:genres;type:text[]"` // Slice of genres for movie
 	Version   int32          `json:"version" gorm:"column:version"`                     // The version number starts at 1 and increment when movie information updated
+
+	AverageRating float64 `json:"average_rating" gorm:"column:average_rating"` // mean review rating, kept up to date by the database
+	RatingCount   int32   `json:"rating_count" gorm:"column:rating_count"`     // number of reviews
 }
 
 func (Movie) TableName() string { return "movies" }
 
+// ValidateMovie() checks the movie, the genres are checked against the taxonomy separately by
+// ValidateMovieGenres() so that movies keeping genres from before the taxonomy can be updated
 func ValidateMovie(v *validator.Validator, movie *Movie) {
 	v.Check(movie.Title != "", "title", "must be provided")
 	v.Check(len(movie.Title) <= 500, "title", "must not be more than 500 bytes long")
@@ -37,6 +42,13 @@ func ValidateMovie(v *validator.Validator, movie *Movie) {
 	v.Check(validator.Unique(movie.Genres), "genres", "must not contain duplicate values")
 }
 
+// ValidateMovieGenres() checks that genres given for a movie are slugs of the genres taxonomy
+func ValidateMovieGenres(v *validator.Validator, genres []string, taxonomy GenreTaxonomy) {
+	for _, genre := range genres {
+		v.Check(taxonomy.Contains(genre), "genres", fmt.Sprintf("%q is not a known genre", genre))
+	}
+}
+
 type MovieModel struct {
 	DB *gorm.DB
 }
@@ -46,7 +58,7 @@ func (m MovieModel) Insert(movie *Movie) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	if err := m.DB.WithContext(ctx).Omit("ID", "CreatedAt", "Version").Create(movie).Error; err != nil {
+	if err := m.DB.WithContext(ctx).Omit("ID", "CreatedAt", "Version", "AverageRating", "RatingCount").Create(movie).Error; err != nil {
 		return err
 	}
 	movie.Version = 1
@@ -86,7 +98,7 @@ func (m MovieModel) Update(movie *Movie) error {
 		WithContext(ctx).
 		Model(&movie).
 		Where("version = ?", movie.Version-1).
-		Omit("ID", "CreatedAt").
+		Omit("ID", "CreatedAt", "AverageRating", "RatingCount").
 		Updates(movie)
 
 	if result.Error != nil {
@@ -121,7 +133,18 @@ func (m MovieModel) Delete(id int64) error {
 	return nil
 }
 
-func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+// MovieQuery holds the optional filters of MovieModel.GetAll(), zero values don't filter
+type MovieQuery struct {
+	Title   string   // full-text match on the title
+	Genres  []string // movies with all of these genres
+	Watched *bool    // whether UserID logged the movie as watched
+	UserID  int64
+
+	PersonID   int64  // movies the person is credited on
+	PersonRole string // narrows PersonID to credits with this role
+}
+
+func (m MovieModel) GetAll(query MovieQuery, filters Filters) ([]*Movie, Metadata, error) {
 	// Example Query for this method :
 	// SELECT count(*) OVER() ,id, created_at, title, year, runtime, genres, version
 	// FROM movies
@@ -136,10 +159,24 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 	movies := []*Movie{}
 	var totalRecords int64
 
-	if err := m.DB.
+	db := m.DB.
 		WithContext(ctx).
 		Table("movies").
-		Where("(to_tsvector('simple', title) @@ plainto_tsquery('simple', ?) OR ? = '') AND (genres @> ? OR ? = '{}')", title, title, pq.StringArray(genres), pq.StringArray(genres)).
+		Where("(to_tsvector('simple', title) @@ plainto_tsquery('simple', ?) OR ? = '') AND (genres @> ? OR ? = '{}')", query.Title, query.Title, pq.StringArray(query.Genres), pq.StringArray(query.Genres))
+
+	if query.Watched != nil {
+		watched := "EXISTS (SELECT 1 FROM watched_movies WHERE watched_movies.user_id = ? AND watched_movies.movie_id = movies.id)"
+		if !*query.Watched {
+			watched = "NOT " + watched
+		}
+		db = db.Where(watched, query.UserID)
+	}
+
+	if query.PersonID != 0 {
+		db = db.Where("EXISTS (SELECT 1 FROM movie_credits WHERE movie_credits.movie_id = movies.id AND movie_credits.person_id = ? AND (movie_credits.role = ? OR ? = ''))", query.PersonID, query.PersonRole, query.PersonRole)
+	}
+
+	if err := db.
 		Count(&totalRecords).
 		Order(fmt.Sprintf("%s %s, id ASC", filters.sortColumn(), filters.sortDirection())).
 		Limit(filters.limit()).
@@ -149,7 +186,7 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 		return nil, Metadata{}, err
 	}
 
-	metadata := calculateMetadata(int(totalRecords), filters.Page, filters.PageSize)
+	metadata := CalculateMetadata(int(totalRecords), filters.Page, filters.PageSize)
 
 	return movies, metadata, nil
 }
@@ -1,13 +1,177 @@
 package data
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
 	"database/sql/driver"
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"strings"
 
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
 )
 
+var ErrUnknownPasswordHash = errors.New("unknown password hash format")
+
+// PasswordHasher hashes new passwords and verifies plaintext passwords against hashes
+// it produced
+type PasswordHasher interface {
+	Hash(plaintextPassword string) ([]byte, error)
+	Matches(hash []byte, plaintextPassword string) (bool, error)
+
+	// NeedsRehash() reports whether a stored hash was produced by another algorithm
+	// or with other parameters than the ones this hasher is configured with
+	NeedsRehash(hash []byte) bool
+
+	// MaxLength() is the longest password in bytes the algorithm takes fully into account
+	MaxLength() int
+}
+
+// passwordHasher is used for every new hash, stored hashes are verified by
+// whichever algorithm produced them
+var passwordHasher PasswordHasher = BcryptHasher{Cost: 12}
+
+// SetPasswordHasher() changes the algorithm used to hash new passwords
+func SetPasswordHasher(h PasswordHasher) {
+	passwordHasher = h
+}
+
+// verifierFor() picks the algorithm that produced a stored hash from its prefix
+func verifierFor(hash []byte) (PasswordHasher, error) {
+	switch {
+	case strings.HasPrefix(string(hash), "$argon2id$"):
+		return Argon2idHasher{}, nil
+	case strings.HasPrefix(string(hash), "$2"):
+		return BcryptHasher{}, nil
+	default:
+		return nil, ErrUnknownPasswordHash
+	}
+}
+
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h BcryptHasher) Hash(plaintextPassword string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword([]byte(plaintextPassword), h.Cost)
+}
+
+func (h BcryptHasher) Matches(hash []byte, plaintextPassword string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword(hash, []byte(plaintextPassword))
+	if err != nil {
+		switch {
+		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func (h BcryptHasher) NeedsRehash(hash []byte) bool {
+	cost, err := bcrypt.Cost(hash)
+	return err != nil || cost != h.Cost
+}
+
+// bcrypt silently truncates passwords longer than 72 bytes
+func (h BcryptHasher) MaxLength() int {
+	return 72
+}
+
+// Argon2idParams are the argon2id cost parameters, Memory is in KiB
+type Argon2idParams struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// Argon2idHasher stores hashes in the PHC string format:
+// $argon2id$v=19$m=<memory>,t=<iterations>,p=<parallelism>$<salt>$<key>
+type Argon2idHasher struct {
+	Params Argon2idParams
+}
+
+func (h Argon2idHasher) Hash(plaintextPassword string) ([]byte, error) {
+	salt := make([]byte, h.Params.SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	key := argon2.IDKey([]byte(plaintextPassword), salt, h.Params.Iterations, h.Params.Memory, h.Params.Parallelism, h.Params.KeyLength)
+
+	encoded := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		h.Params.Memory, h.Params.Iterations, h.Params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	)
+
+	return []byte(encoded), nil
+}
+
+func (h Argon2idHasher) Matches(hash []byte, plaintextPassword string) (bool, error) {
+	params, salt, key, err := decodeArgon2idHash(hash)
+	if err != nil {
+		return false, err
+	}
+
+	// hash the plaintext with the parameters the stored hash was created with
+	otherKey := argon2.IDKey([]byte(plaintextPassword), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+
+	return subtle.ConstantTimeCompare(key, otherKey) == 1, nil
+}
+
+func (h Argon2idHasher) NeedsRehash(hash []byte) bool {
+	params, _, _, err := decodeArgon2idHash(hash)
+	return err != nil || params != h.Params
+}
+
+func (h Argon2idHasher) MaxLength() int {
+	return 256
+}
+
+func decodeArgon2idHash(hash []byte) (Argon2idParams, []byte, []byte, error) {
+	var params Argon2idParams
+
+	parts := strings.Split(string(hash), "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism)
+	if err != nil {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+
+	return params, salt, key, nil
+}
+
 type password struct {
 	plaintext *string
 	hash      []byte
@@ -29,10 +193,10 @@ func (j password) Value() (driver.Value, error) {
 	return j.hash, nil
 }
 
-// Set() method calculates the bcrypt hash of plain password
+// Set() method calculates the hash of plain password with the configured hasher
 // and stores both the hash and plain version in the struct
 func (p *password) Set(plaintextPassword string) error {
-	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), 12)
+	hash, err := passwordHasher.Hash(plaintextPassword)
 	if err != nil {
 		return err
 	}
@@ -44,17 +208,28 @@ func (p *password) Set(plaintextPassword string) error {
 }
 
 // Matches() method checks whether the provided plaintext password matches
-// the hashed password stored in the struct
+// the hashed password stored in the struct, whichever algorithm produced it
 func (p *password) Matches(plaintextPassword string) (bool, error) {
-	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintextPassword))
+	verifier, err := verifierFor(p.hash)
 	if err != nil {
-		switch {
-		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
-			return false, nil
-		default:
-			return false, err
-		}
+		return false, err
 	}
 
-	return true, nil
+	return verifier.Matches(p.hash, plaintextPassword)
+}
+
+// NeedsRehash() reports whether the stored hash is outdated compared to the configured hasher
+func (p *password) NeedsRehash() bool {
+	return passwordHasher.NeedsRehash(p.hash)
+}
+
+// SetRandom() sets a password nobody knows, for accounts created through single sign-on.
+// The user can still choose a password of their own with a password reset.
+func (p *password) SetRandom() error {
+	plaintext, err := randomString()
+	if err != nil {
+		return err
+	}
+
+	return p.Set(plaintext)
 }
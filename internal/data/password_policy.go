@@ -0,0 +1,204 @@
+package data
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+
+	"github.com/nhan10132020/greenlight/internal/validator"
+)
+
+// BreachedPasswords tells whether a password is known to have leaked in a data breach
+type BreachedPasswords interface {
+	Count(password string) (int, error)
+}
+
+// PasswordPolicy holds the rules a new password must follow on top of its length
+type PasswordPolicy struct {
+	MinScore         int               // minimum PasswordStrength() score, 0 accepts any password
+	DisallowPersonal bool              // refuse passwords containing the user's name or email address
+	Breached         BreachedPasswords // nil skips the breached-password check
+}
+
+// passwordPolicy applies to passwords being set, never to passwords checked at login
+var passwordPolicy = PasswordPolicy{}
+
+// SetPasswordPolicy() changes the rules new passwords must follow
+func SetPasswordPolicy(p PasswordPolicy) {
+	passwordPolicy = p
+}
+
+// ValidatePasswordPolicy() checks a new password of the user named name with the email address
+// against the configured policy. The error returned is the failure to look the password up in
+// the breached-password list, which the caller must not take as the password being accepted.
+func ValidatePasswordPolicy(v *validator.Validator, password, name, email string) error {
+	if passwordPolicy.MinScore > 0 {
+		v.Check(PasswordStrength(password) >= passwordPolicy.MinScore, "password", "is too easy to guess, use a longer password or an uncommon phrase")
+	}
+
+	if passwordPolicy.DisallowPersonal {
+		v.Check(!containsPersonalInfo(password, name, email), "password", "must not contain your name or email address")
+	}
+
+	if passwordPolicy.Breached != nil {
+		count, err := passwordPolicy.Breached.Count(password)
+		if err != nil {
+			return fmt.Errorf("breached password check: %w", err)
+		}
+		v.Check(count == 0, "password", "has appeared in a data breach, choose a different password")
+	}
+
+	return nil
+}
+
+func containsPersonalInfo(password, name, email string) bool {
+	password = strings.ToLower(password)
+
+	parts := strings.Fields(strings.ToLower(name))
+	if local, _, ok := strings.Cut(strings.ToLower(email), "@"); ok {
+		parts = append(parts, local)
+	}
+
+	for _, part := range parts {
+		// very short parts would refuse too many unrelated passwords
+		if len(part) >= 3 && strings.Contains(password, part) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// commonPasswords are refused whatever their apparent strength
+var commonPasswords = map[string]bool{
+	"password": true, "password1": true, "password123": true, "passw0rd": true, "p@ssw0rd": true,
+	"12345678": true, "123456789": true, "1234567890": true, "87654321": true, "11111111": true,
+	"qwertyuiop": true, "qwerty123": true, "1q2w3e4r": true, "1qaz2wsx": true, "asdfghjkl": true,
+	"iloveyou": true, "sunshine": true, "princess": true, "football": true, "baseball": true,
+	"welcome1": true, "letmein1": true, "trustno1": true, "superman": true, "starwars": true,
+	"whatever": true, "dragon123": true, "monkey123": true, "abc12345": true, "admin123": true,
+	"greenlight": true, "changeme": true, "computer": true, "internet": true, "michelle": true,
+}
+
+// keyboard and alphabetical runs, a password following one is much weaker than its length suggests
+var sequences = []string{
+	"abcdefghijklmnopqrstuvwxyz",
+	"0123456789",
+	"qwertyuiop",
+	"asdfghjkl",
+	"zxcvbnm",
+}
+
+// PasswordStrength() scores how hard a password is to guess from 0 (trivial) to 4 (very
+// strong), in the manner of zxcvbn. The estimate counts the bits of entropy of the character
+// classes used, after collapsing repeated characters and keyboard or alphabetical sequences.
+func PasswordStrength(password string) int {
+	lower := strings.ToLower(password)
+	if commonPasswords[lower] {
+		return 0
+	}
+
+	var lowers, uppers, digits, symbols bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lowers = true
+		case unicode.IsUpper(r):
+			uppers = true
+		case unicode.IsDigit(r):
+			digits = true
+		default:
+			symbols = true
+		}
+	}
+
+	charset := 0
+	if lowers {
+		charset += 26
+	}
+	if uppers {
+		charset += 26
+	}
+	if digits {
+		charset += 10
+	}
+	if symbols {
+		charset += 33
+	}
+	if charset == 0 {
+		return 0
+	}
+
+	bits := float64(effectiveLength(lower)) * math.Log2(float64(charset))
+
+	switch {
+	case bits < 28:
+		return 0
+	case bits < 40:
+		return 1
+	case bits < 55:
+		return 2
+	case bits < 70:
+		return 3
+	default:
+		return 4
+	}
+}
+
+// effectiveLength() counts a run of the same character, or a run of at least three
+// characters of a sequence, as a single character
+func effectiveLength(password string) int {
+	runes := []rune(password)
+	length := 0
+
+	for i := 0; i < len(runes); {
+		j := i + 1
+		for j < len(runes) && runes[j] == runes[i] {
+			j++
+		}
+
+		if j == i+1 {
+			if k := sequenceEnd(runes, i); k-i >= 3 {
+				j = k
+			}
+		}
+
+		length++
+		i = j
+	}
+
+	return length
+}
+
+// sequenceEnd() returns the end of the longest sequence, forwards or backwards, starting at i
+func sequenceEnd(runes []rune, i int) int {
+	end := i + 1
+
+	for _, seq := range sequences {
+		for _, s := range []string{seq, reverse(seq)} {
+			pos := strings.IndexRune(s, runes[i])
+			if pos < 0 {
+				continue
+			}
+
+			j := i + 1
+			for j < len(runes) && pos+j-i < len(s) && rune(s[pos+j-i]) == runes[j] {
+				j++
+			}
+			if j > end {
+				end = j
+			}
+		}
+	}
+
+	return end
+}
+
+func reverse(s string) string {
+	b := []byte(s)
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return string(b)
+}
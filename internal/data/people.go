@@ -0,0 +1,270 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/nhan10132020/greenlight/internal/validator"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrDuplicateCredit = errors.New("duplicate credit")
+)
+
+// the roles a person can be credited for on a movie
+const (
+	CreditRoleDirector = "director"
+	CreditRoleWriter   = "writer"
+	CreditRoleActor    = "actor"
+)
+
+var CreditRoles = []string{CreditRoleDirector, CreditRoleWriter, CreditRoleActor}
+
+// Person is someone who worked on movies, as cast or crew
+type Person struct {
+	ID        int64     `json:"id" gorm:"column:id"`
+	CreatedAt time.Time `json:"-" gorm:"column:created_at"`
+	Name      string    `json:"name" gorm:"column:name"`
+	BirthYear *int32    `json:"birth_year,omitempty" gorm:"column:birth_year"`
+	Bio       string    `json:"bio,omitempty" gorm:"column:bio"`
+	Version   int32     `json:"version" gorm:"column:version"`
+}
+
+func (Person) TableName() string { return "people" }
+
+func ValidatePerson(v *validator.Validator, person *Person) {
+	v.Check(person.Name != "", "name", "must be provided")
+	v.Check(len(person.Name) <= 500, "name", "must not be more than 500 bytes long")
+	if person.BirthYear != nil {
+		v.Check(*person.BirthYear >= 1800, "birth_year", "must be greater than 1800")
+		v.Check(*person.BirthYear <= int32(time.Now().Year()), "birth_year", "must not be in the future")
+	}
+	v.Check(len(person.Bio) <= 10_000, "bio", "must not be more than 10000 bytes long")
+}
+
+type PersonModel struct {
+	DB *gorm.DB
+}
+
+func (m PersonModel) Insert(person *Person) error {
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := m.DB.WithContext(ctx).Omit("ID", "CreatedAt", "Version").Create(person).Error; err != nil {
+		return err
+	}
+	person.Version = 1
+	return nil
+}
+
+func (m PersonModel) Get(id int64) (*Person, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	var person Person
+
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := m.DB.WithContext(ctx).Where("id = ?", id).First(&person).Error; err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &person, nil
+}
+
+func (m PersonModel) Update(person *Person) error {
+	person.Version += 1
+
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// at condition on "version" field to avoid data race existing, the fields are
+	// selected explicitly as the birth year and bio may be cleared
+	result := m.DB.
+		WithContext(ctx).
+		Model(person).
+		Where("version = ?", person.Version-1).
+		Select("Name", "BirthYear", "Bio", "Version").
+		Updates(person)
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrEditConflict
+	}
+	return nil
+}
+
+func (m PersonModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result := m.DB.WithContext(ctx).Delete(&Person{}, id)
+
+	if err := result.Error; err != nil {
+		return err
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (m PersonModel) GetAll(name string, filters Filters) ([]*Person, Metadata, error) {
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	people := []*Person{}
+	var totalRecords int64
+
+	if err := m.DB.
+		WithContext(ctx).
+		Model(&Person{}).
+		Where("to_tsvector('simple', name) @@ plainto_tsquery('simple', ?) OR ? = ''", name, name).
+		Count(&totalRecords).
+		Order(fmt.Sprintf("%s %s, id ASC", filters.sortColumn(), filters.sortDirection())).
+		Limit(filters.limit()).
+		Offset(filters.offset()).
+		Find(&people).
+		Error; err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return people, CalculateMetadata(int(totalRecords), filters.Page, filters.PageSize), nil
+}
+
+// Credit is the part a person had in a movie
+type Credit struct {
+	ID           int64   `json:"id" gorm:"column:id"`
+	MovieID      int64   `json:"movie_id" gorm:"column:movie_id"`
+	PersonID     int64   `json:"person_id" gorm:"column:person_id"`
+	Role         string  `json:"role" gorm:"column:role"`                     // one of CreditRoles
+	Character    string  `json:"character,omitempty" gorm:"column:character"` // name of the character played, for actors
+	BillingOrder int32   `json:"billing_order" gorm:"column:billing_order"`   // position in the credits, lowest first
+	Person       *Person `json:"person,omitempty" gorm:"foreignKey:PersonID"`
+}
+
+func (Credit) TableName() string { return "movie_credits" }
+
+func ValidateCredit(v *validator.Validator, credit *Credit) {
+	v.Check(credit.PersonID > 0, "person_id", "must be provided")
+	v.Check(credit.Role != "", "role", "must be provided")
+	v.Check(validator.In(credit.Role, CreditRoles...), "role", "must be one of "+strings.Join(CreditRoles, ", "))
+	v.Check(credit.Role == CreditRoleActor || credit.Character == "", "character", "must only be provided for actors")
+	v.Check(len(credit.Character) <= 500, "character", "must not be more than 500 bytes long")
+	v.Check(credit.BillingOrder >= 0, "billing_order", "must not be negative")
+}
+
+type CreditModel struct {
+	DB *gorm.DB
+}
+
+func (m CreditModel) Insert(credit *Credit) error {
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := m.DB.WithContext(ctx).Omit("ID", "Person").Create(credit).Error; err != nil {
+		var perr *pgconn.PgError
+		if errors.As(err, &perr) {
+			switch perr.Code {
+			case "23505":
+				return ErrDuplicateCredit
+			case "23503":
+				// the movie or the person doesn't exist
+				return ErrRecordNotFound
+			}
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (m CreditModel) Get(id int64) (*Credit, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	var credit Credit
+
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := m.DB.WithContext(ctx).Where("id = ?", id).First(&credit).Error; err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &credit, nil
+}
+
+// GetAllForMovie() returns the credits of the movie with the people, directors first,
+// then writers and actors, each in billing order
+func (m CreditModel) GetAllForMovie(movieID int64) ([]*Credit, error) {
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	credits := []*Credit{}
+
+	if err := m.DB.
+		WithContext(ctx).
+		Where("movie_id = ?", movieID).
+		Preload("Person").
+		Order("CASE role WHEN 'director' THEN 1 WHEN 'writer' THEN 2 ELSE 3 END, billing_order ASC, id ASC").
+		Find(&credits).
+		Error; err != nil {
+		return nil, err
+	}
+
+	return credits, nil
+}
+
+func (m CreditModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result := m.DB.WithContext(ctx).Delete(&Credit{}, id)
+
+	if err := result.Error; err != nil {
+		return err
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
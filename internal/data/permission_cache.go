@@ -0,0 +1,158 @@
+package data
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PermissionsChannel is the Postgres channel on which permission changes are announced, the
+// payload is the ID of the affected user or empty when every user may be affected
+const PermissionsChannel = "permissions_changed"
+
+// the cache is swept of expired entries once it grows past this size
+const permissionCacheSweepSize = 10000
+
+type permissionCacheEntry struct {
+	permissions Permissions
+	expiry      time.Time
+}
+
+// permissionCacheGeneration identifies the invalidations a load of permissions started after
+type permissionCacheGeneration struct {
+	all  uint64
+	user uint64
+}
+
+// PermissionCache keeps the effective permissions of users in memory for a short while
+type PermissionCache struct {
+	ttl     time.Duration
+	mu      sync.RWMutex
+	entries map[int64]permissionCacheEntry
+
+	// bumped by every invalidation, so that permissions loaded before one aren't cached after it
+	allGeneration   uint64
+	userGenerations map[int64]uint64
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+func NewPermissionCache(ttl time.Duration) *PermissionCache {
+	return &PermissionCache{
+		ttl:             ttl,
+		entries:         make(map[int64]permissionCacheEntry),
+		userGenerations: make(map[int64]uint64),
+	}
+}
+
+// Get() returns the cached permissions of the user, if they have not expired yet
+func (c *PermissionCache) Get(userID int64) (Permissions, bool) {
+	c.mu.RLock()
+	entry, ok := c.entries[userID]
+	c.mu.RUnlock()
+
+	if !ok || time.Now().After(entry.expiry) {
+		c.misses.Add(1)
+		return nil, false
+	}
+
+	c.hits.Add(1)
+	return entry.permissions, true
+}
+
+// generation() must be called before the permissions of the user are loaded, to be passed
+// to Set() with them
+func (c *PermissionCache) generation(userID int64) permissionCacheGeneration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return permissionCacheGeneration{all: c.allGeneration, user: c.userGenerations[userID]}
+}
+
+// Set() caches the permissions of the user unless they were invalidated since generation,
+// the permissions loaded may then predate the change
+func (c *PermissionCache) Set(userID int64, permissions Permissions, generation permissionCacheGeneration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != (permissionCacheGeneration{all: c.allGeneration, user: c.userGenerations[userID]}) {
+		return
+	}
+
+	now := time.Now()
+
+	if len(c.entries) >= permissionCacheSweepSize {
+		for id, entry := range c.entries {
+			if now.After(entry.expiry) {
+				delete(c.entries, id)
+			}
+		}
+	}
+
+	c.entries[userID] = permissionCacheEntry{
+		permissions: permissions,
+		expiry:      now.Add(c.ttl),
+	}
+}
+
+// Invalidate() drops the cached permissions of the user
+func (c *PermissionCache) Invalidate(userID int64) {
+	c.mu.Lock()
+	delete(c.entries, userID)
+	c.userGenerations[userID]++
+	c.mu.Unlock()
+}
+
+// InvalidateAll() drops the cached permissions of every user
+func (c *PermissionCache) InvalidateAll() {
+	c.mu.Lock()
+	c.entries = make(map[int64]permissionCacheEntry)
+	// the per-user counters can start over as the global one changed
+	c.allGeneration++
+	c.userGenerations = make(map[int64]uint64)
+	c.mu.Unlock()
+}
+
+// InvalidatePayload() applies a notification received on PermissionsChannel
+func (c *PermissionCache) InvalidatePayload(payload string) {
+	userID, err := strconv.ParseInt(payload, 10, 64)
+	if err != nil {
+		c.InvalidateAll()
+		return
+	}
+
+	c.Invalidate(userID)
+}
+
+// Stats() reports the cache size and hit/miss counters, in a shape suitable for expvar
+func (c *PermissionCache) Stats() map[string]int64 {
+	c.mu.RLock()
+	size := len(c.entries)
+	c.mu.RUnlock()
+
+	return map[string]int64{
+		"entries": int64(size),
+		"hits":    c.hits.Load(),
+		"misses":  c.misses.Load(),
+	}
+}
+
+// permissionsChanged() invalidates the local cache and tells every other API instance
+// listening on PermissionsChannel to do the same. A zero userID stands for all users.
+// When called inside a transaction the notification is only delivered on commit.
+func permissionsChanged(db *gorm.DB, cache *PermissionCache, userID int64) error {
+	payload := ""
+	if userID != 0 {
+		payload = strconv.FormatInt(userID, 10)
+	}
+
+	if cache != nil {
+		cache.InvalidatePayload(payload)
+	}
+
+	return db.Exec("SELECT pg_notify(?, ?)", PermissionsChannel, payload).Error
+}
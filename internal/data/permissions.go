@@ -2,7 +2,6 @@ package data
 
 import (
 	"context"
-	"fmt"
 	"time"
 
 	"github.com/lib/pq"
@@ -22,10 +21,71 @@ func (p Permissions) Include(code string) bool {
 }
 
 type PermissionsModel struct {
-	DB *gorm.DB
+	DB    *gorm.DB
+	Cache *PermissionCache // nil disables caching
 }
 
+// GetAll() returns every permission code which can be granted
+func (m PermissionsModel) GetAll() (Permissions, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var permissions Permissions
+
+	if err := m.DB.
+		WithContext(ctx).
+		Table("permissions").
+		Order("code").
+		Select("code").Find(&permissions).Error; err != nil {
+
+		return nil, err
+	}
+
+	return permissions, nil
+}
+
+// GetAllForUser() returns the effective permissions of the user: the ones granted
+// directly together with the ones bundled in the roles assigned to the user
 func (m PermissionsModel) GetAllForUser(userID int64) (Permissions, error) {
+	var generation permissionCacheGeneration
+
+	if m.Cache != nil {
+		if permissions, ok := m.Cache.Get(userID); ok {
+			return permissions, nil
+		}
+		generation = m.Cache.generation(userID)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var permissions Permissions
+
+	if err := m.DB.
+		WithContext(ctx).
+		Raw(`SELECT permissions.code FROM permissions
+			INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
+			WHERE users_permissions.user_id = ?
+			UNION
+			SELECT permissions.code FROM permissions
+			INNER JOIN roles_permissions ON roles_permissions.permission_id = permissions.id
+			INNER JOIN users_roles ON users_roles.role_id = roles_permissions.role_id
+			WHERE users_roles.user_id = ?
+			ORDER BY code`, userID, userID).
+		Scan(&permissions).Error; err != nil {
+
+		return nil, err
+	}
+
+	if m.Cache != nil {
+		m.Cache.Set(userID, permissions, generation)
+	}
+
+	return permissions, nil
+}
+
+// GetDirectForUser() returns only the permissions granted to the user individually
+func (m PermissionsModel) GetDirectForUser(userID int64) (Permissions, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
@@ -36,6 +96,7 @@ func (m PermissionsModel) GetAllForUser(userID int64) (Permissions, error) {
 		Table("permissions").
 		Joins("inner join users_permissions ON users_permissions.permission_id = permissions.id").
 		Where("user_id = ?", userID).
+		Order("code").
 		Select("code").Find(&permissions).Error; err != nil {
 
 		return nil, err
@@ -50,12 +111,24 @@ func (m PermissionsModel) AddForUser(userID int64, codes ...string) error {
 
 	if err := m.DB.
 		WithContext(ctx).
-		Exec("INSERT INTO users_permissions SELECT ?, permissions.id FROM permissions WHERE permissions.code=ANY(?)", userID, pq.StringArray(codes)).
+		Exec("INSERT INTO users_permissions SELECT ?, permissions.id FROM permissions WHERE permissions.code=ANY(?) ON CONFLICT DO NOTHING", userID, pq.StringArray(codes)).
 		Error; err != nil {
 		return err
 	}
 
-	fmt.Println()
+	return permissionsChanged(m.DB.WithContext(ctx), m.Cache, userID)
+}
+
+func (m PermissionsModel) RemoveForUser(userID int64, codes ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := m.DB.
+		WithContext(ctx).
+		Exec("DELETE FROM users_permissions USING permissions WHERE users_permissions.permission_id = permissions.id AND users_permissions.user_id = ? AND permissions.code=ANY(?)", userID, pq.StringArray(codes)).
+		Error; err != nil {
+		return err
+	}
 
-	return nil
+	return permissionsChanged(m.DB.WithContext(ctx), m.Cache, userID)
 }
@@ -0,0 +1,89 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// number of recovery codes issued when two-factor authentication is enabled
+const recoveryCodeCount = 10
+
+type RecoveryCode struct {
+	Hash   []byte `gorm:"column:hash"`
+	UserID int64  `gorm:"column:user_id"`
+}
+
+func (RecoveryCode) TableName() string { return "recovery_codes" }
+
+// normalizeRecoveryCode() makes the comparison insensitive to case and to the dash
+// the codes are displayed with
+func normalizeRecoveryCode(code string) string {
+	return strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
+
+func hashRecoveryCode(code string) []byte {
+	hash := sha256.Sum256([]byte(normalizeRecoveryCode(code)))
+	return hash[:]
+}
+
+type RecoveryCodeModel struct {
+	DB *gorm.DB
+}
+
+// Replace() discards the existing recovery codes of the user and returns a fresh set.
+// Only the hashes are stored, so the plaintext codes can only be shown this once.
+func (m RecoveryCodeModel) Replace(userID int64) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	rows := make([]*RecoveryCode, recoveryCodeCount)
+
+	for i := range codes {
+		// 50 bits of entropy per code, enough for a single-use code which is only
+		// accepted together with the user's password
+		randomBytes := make([]byte, 10)
+		_, err := rand.Read(randomBytes)
+		if err != nil {
+			return nil, err
+		}
+
+		encoded := base32.StdEncoding.EncodeToString(randomBytes)[:10]
+		codes[i] = strings.ToLower(encoded[:5] + "-" + encoded[5:])
+		rows[i] = &RecoveryCode{Hash: hashRecoveryCode(codes[i]), UserID: userID}
+	}
+
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(rows).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// Use() consumes a recovery code, reporting whether it was valid
+func (m RecoveryCodeModel) Use(userID int64, code string) (bool, error) {
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result := m.DB.WithContext(ctx).Where("hash = ? AND user_id = ?", hashRecoveryCode(code), userID).Delete(&RecoveryCode{})
+	if err := result.Error; err != nil {
+		return false, err
+	}
+
+	return result.RowsAffected == 1, nil
+}
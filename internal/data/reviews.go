@@ -0,0 +1,173 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/nhan10132020/greenlight/internal/validator"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrDuplicateReview = errors.New("duplicate review")
+)
+
+// Review is the rating of a movie by a user, with an optional text. A user reviews a movie
+// at most once.
+type Review struct {
+	ID        int64     `json:"id" gorm:"column:id"`
+	CreatedAt time.Time `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt time.Time `json:"updated_at" gorm:"column:updated_at"`
+	MovieID   int64     `json:"movie_id" gorm:"column:movie_id"`
+	UserID    int64     `json:"user_id" gorm:"column:user_id"` // author of the review
+	Rating    int32     `json:"rating" gorm:"column:rating"`   // from 1 to 10
+	Body      string    `json:"body" gorm:"column:body"`
+	Version   int32     `json:"version" gorm:"column:version"`
+}
+
+func (Review) TableName() string { return "reviews" }
+
+func ValidateReview(v *validator.Validator, review *Review) {
+	v.Check(review.Rating != 0, "rating", "must be provided")
+	v.Check(review.Rating >= 1 && review.Rating <= 10, "rating", "must be between 1 and 10")
+	v.Check(len(review.Body) <= 10_000, "body", "must not be more than 10000 bytes long")
+}
+
+type ReviewModel struct {
+	DB *gorm.DB
+}
+
+func (m ReviewModel) Insert(review *Review) error {
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := m.DB.WithContext(ctx).Omit("ID", "CreatedAt", "UpdatedAt", "Version").Create(review).Error; err != nil {
+		var perr *pgconn.PgError
+		if errors.As(err, &perr) {
+			switch {
+			case perr.Code == "23505" && strings.Contains(perr.Message, "reviews_movie_id_user_id_key"):
+				return ErrDuplicateReview
+			case perr.Code == "23503" && strings.Contains(perr.ConstraintName, "movie_id"):
+				// the movie was deleted in the meantime
+				return ErrRecordNotFound
+			}
+		}
+		return err
+	}
+	review.Version = 1
+	return nil
+}
+
+func (m ReviewModel) Get(id int64) (*Review, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	var review Review
+
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := m.DB.WithContext(ctx).Where("id = ?", id).First(&review).Error; err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &review, nil
+}
+
+// GetAllForMovie() returns a page of the reviews of the movie
+func (m ReviewModel) GetAllForMovie(movieID int64, filters Filters) ([]*Review, Metadata, error) {
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	reviews := []*Review{}
+	var totalRecords int64
+
+	if err := m.DB.
+		WithContext(ctx).
+		Model(&Review{}).
+		Where("movie_id = ?", movieID).
+		Count(&totalRecords).
+		Order(fmt.Sprintf("%s %s, id ASC", filters.sortColumn(), filters.sortDirection())).
+		Limit(filters.limit()).
+		Offset(filters.offset()).
+		Find(&reviews).
+		Error; err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return reviews, CalculateMetadata(int(totalRecords), filters.Page, filters.PageSize), nil
+}
+
+func (m ReviewModel) GetAllForUser(userID int64) ([]*Review, error) {
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	reviews := []*Review{}
+
+	if err := m.DB.WithContext(ctx).Where("user_id = ?", userID).Order("id ASC").Find(&reviews).Error; err != nil {
+		return nil, err
+	}
+
+	return reviews, nil
+}
+
+func (m ReviewModel) Update(review *Review) error {
+	review.Version += 1
+	review.UpdatedAt = time.Now()
+
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// at condition on "version" field to avoid data race existing, the body is
+	// selected explicitly as it may be cleared
+	result := m.DB.
+		WithContext(ctx).
+		Model(review).
+		Where("version = ?", review.Version-1).
+		Select("Rating", "Body", "Version", "UpdatedAt").
+		Updates(review)
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrEditConflict
+	}
+	return nil
+}
+
+func (m ReviewModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result := m.DB.WithContext(ctx).Delete(&Review{}, id)
+
+	if err := result.Error; err != nil {
+		return err
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
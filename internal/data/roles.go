@@ -0,0 +1,227 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lib/pq"
+	"github.com/nhan10132020/greenlight/internal/validator"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrDuplicateRoleName = errors.New("duplicate role name")
+)
+
+// Role bundles permission codes so they can be granted to users together
+type Role struct {
+	ID          int64          `json:"id" gorm:"column:id"`
+	CreatedAt   time.Time      `json:"-" gorm:"column:created_at"`
+	Name        string         `json:"name" gorm:"column:name"`
+	Description string         `json:"description" gorm:"column:description"`
+	Permissions pq.StringArray `json:"permissions" gorm:"column:permissions;->"` // aggregated from roles_permissions, never written to roles
+	Version     int32          `json:"version" gorm:"column:version"`
+}
+
+func (Role) TableName() string { return "roles" }
+
+func ValidateRole(v *validator.Validator, role *Role) {
+	v.Check(role.Name != "", "name", "must be provided")
+	v.Check(len(role.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(len(role.Description) <= 500, "description", "must not be more than 500 bytes long")
+	v.Check(role.Permissions != nil, "permissions", "must be provided")
+	v.Check(validator.Unique(role.Permissions), "permissions", "must not contain duplicate values")
+}
+
+// selects roles together with the codes of their permissions
+const roleSelect = `SELECT roles.id, roles.created_at, roles.name, roles.description, roles.version,
+	COALESCE(array_agg(permissions.code ORDER BY permissions.code) FILTER (WHERE permissions.code IS NOT NULL), '{}') AS permissions
+	FROM roles
+	LEFT JOIN roles_permissions ON roles_permissions.role_id = roles.id
+	LEFT JOIN permissions ON permissions.id = roles_permissions.permission_id`
+
+type RoleModel struct {
+	DB    *gorm.DB
+	Cache *PermissionCache // invalidated whenever role assignments change
+}
+
+func (m RoleModel) Insert(role *Role) error {
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("ID", "CreatedAt", "Version").Create(role).Error; err != nil {
+			return roleError(err)
+		}
+		role.Version = 1
+
+		if err := setRolePermissions(tx, role); err != nil {
+			return err
+		}
+
+		return permissionsChanged(tx, m.Cache, 0)
+	})
+}
+
+func (m RoleModel) Get(id int64) (*Role, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	roles := []*Role{}
+
+	if err := m.DB.WithContext(ctx).Raw(roleSelect+" WHERE roles.id = ? GROUP BY roles.id", id).Scan(&roles).Error; err != nil {
+		return nil, err
+	}
+
+	if len(roles) == 0 {
+		return nil, ErrRecordNotFound
+	}
+
+	return roles[0], nil
+}
+
+func (m RoleModel) GetAll() ([]*Role, error) {
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	roles := []*Role{}
+
+	if err := m.DB.WithContext(ctx).Raw(roleSelect + " GROUP BY roles.id ORDER BY roles.name").Scan(&roles).Error; err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// GetAllForUser() returns the names of the roles assigned to the user
+func (m RoleModel) GetAllForUser(userID int64) ([]string, error) {
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	names := []string{}
+
+	if err := m.DB.
+		WithContext(ctx).
+		Table("roles").
+		Joins("inner join users_roles ON users_roles.role_id = roles.id").
+		Where("users_roles.user_id = ?", userID).
+		Order("name").
+		Pluck("name", &names).Error; err != nil {
+		return nil, err
+	}
+
+	return names, nil
+}
+
+// Update() saves the role and replaces its permissions
+func (m RoleModel) Update(role *Role) error {
+	role.Version += 1
+
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// at condition on "version" field to avoid data race existing
+		result := tx.
+			Model(role).
+			Where("version = ?", role.Version-1).
+			Select("Name", "Description", "Version").
+			Updates(role)
+
+		if result.Error != nil {
+			return roleError(result.Error)
+		}
+
+		if result.RowsAffected == 0 {
+			return ErrEditConflict
+		}
+
+		if err := tx.Exec("DELETE FROM roles_permissions WHERE role_id = ?", role.ID).Error; err != nil {
+			return err
+		}
+
+		if err := setRolePermissions(tx, role); err != nil {
+			return err
+		}
+
+		return permissionsChanged(tx, m.Cache, 0)
+	})
+}
+
+func (m RoleModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result := m.DB.WithContext(ctx).Delete(&Role{}, id)
+
+	if err := result.Error; err != nil {
+		return err
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return permissionsChanged(m.DB.WithContext(ctx), m.Cache, 0)
+}
+
+func (m RoleModel) AddForUser(userID int64, names ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := m.DB.
+		WithContext(ctx).
+		Exec("INSERT INTO users_roles SELECT ?, roles.id FROM roles WHERE roles.name=ANY(?) ON CONFLICT DO NOTHING", userID, pq.StringArray(names)).
+		Error; err != nil {
+		return err
+	}
+
+	return permissionsChanged(m.DB.WithContext(ctx), m.Cache, userID)
+}
+
+func (m RoleModel) RemoveForUser(userID int64, names ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := m.DB.
+		WithContext(ctx).
+		Exec("DELETE FROM users_roles USING roles WHERE users_roles.role_id = roles.id AND users_roles.user_id = ? AND roles.name=ANY(?)", userID, pq.StringArray(names)).
+		Error; err != nil {
+		return err
+	}
+
+	return permissionsChanged(m.DB.WithContext(ctx), m.Cache, userID)
+}
+
+func setRolePermissions(tx *gorm.DB, role *Role) error {
+	return tx.
+		Exec("INSERT INTO roles_permissions SELECT ?, permissions.id FROM permissions WHERE permissions.code=ANY(?)", role.ID, role.Permissions).
+		Error
+}
+
+func roleError(err error) error {
+	var perr *pgconn.PgError
+	if errors.As(err, &perr) {
+		if perr.Code == "23505" && strings.Contains(perr.Message, "roles_name_key") {
+			return ErrDuplicateRoleName
+		}
+	}
+	return err
+}
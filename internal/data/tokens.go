@@ -5,46 +5,90 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base32"
+	"errors"
 	"time"
 
 	"github.com/nhan10132020/greenlight/internal/validator"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
+	ScopePasswordReset  = "password-reset"
+	ScopeRefresh        = "refresh"
+	ScopeTwoFactor      = "2fa-pending"
+	ScopeUnlock         = "unlock"
+	ScopeEmailChange    = "email-change"
+	ScopeEmailCancel    = "email-cancel"
+	ScopeMagicLink      = "magic-link"
+	ScopeImpersonation  = "impersonation"
+)
+
+var (
+	ErrTokenReused = errors.New("token reused")
 )
 
 type Token struct {
-	Plaintext string    `json:"token" gorm:"-"`
-	Hash      []byte    `json:"-" gorm:"column:hash"`
-	UserID    int64     `json:"-" gorm:"column:user_id"`
-	Expiry    time.Time `json:"expiry" gorm:"column:expiry"`
-	Scope     string    `json:"-" gorm:"column:scope"`
+	Plaintext  string     `json:"token" gorm:"-"`
+	Hash       []byte     `json:"-" gorm:"column:hash"`
+	UserID     int64      `json:"-" gorm:"column:user_id"`
+	Expiry     time.Time  `json:"expiry" gorm:"column:expiry"`
+	Scope      string     `json:"-" gorm:"column:scope"`
+	CreatedAt  time.Time  `json:"-" gorm:"column:created_at"`
+	LastUsedAt *time.Time `json:"-" gorm:"column:last_used_at"`
+	IP         string     `json:"-" gorm:"column:ip"`
+	UserAgent  string     `json:"-" gorm:"column:user_agent"`
+	Family     string     `json:"-" gorm:"column:family"`  // shared by the tokens issued from one login through refresh rotations
+	UsedAt     *time.Time `json:"-" gorm:"column:used_at"` // set once a refresh token has been rotated
+
+	ImpersonatorID *int64 `json:"-" gorm:"column:impersonator_id"` // staff member acting as the user with an impersonation token
+	ReadOnly       bool   `json:"-" gorm:"column:read_only"`       // whether the impersonation token may only be used for reads
 }
 
 func (Token) TableName() string { return "tokens" }
 
+// ClientInfo describes the client a token is issued to
+type ClientInfo struct {
+	IP        string
+	UserAgent string
+}
+
+// Session is the client-facing view of a token
+type Session struct {
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Expiry     time.Time  `json:"expiry"`
+	IP         string     `json:"ip"`
+	UserAgent  string     `json:"user_agent"`
+	Current    bool       `json:"current"`
+}
+
+func (t *Token) Session(current bool) *Session {
+	return &Session{
+		CreatedAt:  t.CreatedAt,
+		LastUsedAt: t.LastUsedAt,
+		Expiry:     t.Expiry,
+		IP:         t.IP,
+		UserAgent:  t.UserAgent,
+		Current:    current,
+	}
+}
+
 func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
 	token := &Token{
-		UserID: userID,
-		Expiry: time.Now().Add(ttl),
-		Scope:  scope,
+		UserID:    userID,
+		Expiry:    time.Now().Add(ttl),
+		Scope:     scope,
+		CreatedAt: time.Now(),
 	}
 
-	// 128 bits (16 bytes) of entropy
-	randomeBytes := make([]byte, 16)
-
-	// fill the byte slice with random bytes from operating systems's CSPRNG(cryptographically secure random number generator)
-	_, err := rand.Read(randomeBytes)
+	plaintext, err := randomString()
 	if err != nil {
 		return nil, err
 	}
-
-	// encode byte slice to a base-32-encoded string with entropy of 16 bytes.
-	// the length of plaintext is 26 due to base-32 string encoded of 16 bytes
-	token.Plaintext = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomeBytes)
+	token.Plaintext = plaintext
 
 	// generate hash of plaintext by SHA-256 algorithm
 	hash := sha256.Sum256([]byte(token.Plaintext))
@@ -53,6 +97,22 @@ func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error
 	return token, nil
 }
 
+// randomString() returns a base-32-encoded string with 128 bits of entropy
+func randomString() (string, error) {
+	// 128 bits (16 bytes) of entropy
+	randomeBytes := make([]byte, 16)
+
+	// fill the byte slice with random bytes from operating systems's CSPRNG(cryptographically secure random number generator)
+	_, err := rand.Read(randomeBytes)
+	if err != nil {
+		return "", err
+	}
+
+	// encode byte slice to a base-32-encoded string with entropy of 16 bytes.
+	// the length of the string is 26 due to base-32 string encoded of 16 bytes
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomeBytes), nil
+}
+
 // Check that the plaintext token has been provided and is exactly
 // 26 bytes long
 func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
@@ -65,14 +125,142 @@ type TokenModel struct {
 }
 
 func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
+	return m.NewForClient(userID, ttl, scope, "", ClientInfo{})
+}
+
+// NewForClient() creates a token like New(), records the client it was issued to
+// and attaches it to a refresh family (empty when the token doesn't belong to one)
+func (m TokenModel) NewForClient(userID int64, ttl time.Duration, scope, family string, client ClientInfo) (*Token, error) {
 	token, err := generateToken(userID, ttl, scope)
 	if err != nil {
 		return nil, err
 	}
+	token.Family = family
+	token.IP = client.IP
+	token.UserAgent = client.UserAgent
+
 	err = m.Insert(token)
 	return token, err
 }
 
+// NewImpersonation() issues a token letting the impersonator act as the user
+func (m TokenModel) NewImpersonation(userID, impersonatorID int64, ttl time.Duration, readOnly bool, client ClientInfo) (*Token, error) {
+	token, err := generateToken(userID, ttl, ScopeImpersonation)
+	if err != nil {
+		return nil, err
+	}
+	token.ImpersonatorID = &impersonatorID
+	token.ReadOnly = readOnly
+	token.IP = client.IP
+	token.UserAgent = client.UserAgent
+
+	err = m.Insert(token)
+	return token, err
+}
+
+// GetImpersonation() returns the unexpired impersonation token matching the plaintext
+func (m TokenModel) GetImpersonation(tokenPlaintext string) (*Token, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	var token Token
+
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := m.DB.
+		WithContext(ctx).
+		Where("hash = ? AND scope = ? AND expiry > ?", tokenHash[:], ScopeImpersonation, time.Now()).
+		First(&token).
+		Error; err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &token, nil
+}
+
+// NewRefresh() issues a refresh token which starts a new refresh family
+func (m TokenModel) NewRefresh(userID int64, ttl time.Duration, client ClientInfo) (*Token, error) {
+	family, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+
+	return m.NewForClient(userID, ttl, ScopeRefresh, family, client)
+}
+
+// Rotate() exchanges a refresh token for a new one in the same family. The presented refresh
+// token is marked as used rather than deleted, so presenting it a second time is detected as
+// reuse: the whole family is revoked and ErrTokenReused returned.
+func (m TokenModel) Rotate(refreshPlaintext string, ttl time.Duration, client ClientInfo) (*Token, error) {
+	refreshHash := sha256.Sum256([]byte(refreshPlaintext))
+
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var (
+		refreshToken *Token
+		reused       bool
+	)
+
+	err := m.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var current Token
+
+		// lock the row so that two concurrent rotations of the same token can't both succeed
+		if err := tx.
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("hash = ? AND scope = ?", refreshHash[:], ScopeRefresh).
+			First(&current).
+			Error; err != nil {
+			switch {
+			case errors.Is(err, gorm.ErrRecordNotFound):
+				return ErrRecordNotFound
+			default:
+				return err
+			}
+		}
+
+		if current.UsedAt != nil {
+			reused = true
+			return tx.Where("family = ?", current.Family).Delete(&Token{}).Error
+		}
+
+		if !current.Expiry.After(time.Now()) {
+			return ErrRecordNotFound
+		}
+
+		if err := tx.Model(&Token{}).Where("hash = ?", current.Hash).Update("used_at", time.Now()).Error; err != nil {
+			return err
+		}
+
+		var err error
+		refreshToken, err = generateToken(current.UserID, ttl, ScopeRefresh)
+		if err != nil {
+			return err
+		}
+		refreshToken.Family = current.Family
+		refreshToken.IP = client.IP
+		refreshToken.UserAgent = client.UserAgent
+
+		return tx.Create(refreshToken).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if reused {
+		return nil, ErrTokenReused
+	}
+
+	return refreshToken, nil
+}
+
 func (m TokenModel) Insert(token *Token) error {
 	// context 3-second timeout deadline
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -96,3 +284,113 @@ func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
 
 	return nil
 }
+
+// DeleteAllImpersonations() revokes the impersonation tokens acting as the user as well as
+// those the user was issued as an impersonator
+func (m TokenModel) DeleteAllImpersonations(userID int64) error {
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.
+		WithContext(ctx).
+		Where("scope = ? AND (user_id = ? OR impersonator_id = ?)", ScopeImpersonation, userID, userID).
+		Delete(&Token{}).
+		Error
+}
+
+// DeleteEveryScopeForUser() revokes every token of the user, whatever it was issued for
+func (m TokenModel) DeleteEveryScopeForUser(userID int64) error {
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.WithContext(ctx).Where("user_id = ?", userID).Delete(&Token{}).Error
+}
+
+// Delete() revokes a single token identified by its plaintext, together with
+// every other token of its refresh family
+func (m TokenModel) Delete(scope, tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var token Token
+
+	if err := m.DB.WithContext(ctx).Where("hash = ? AND scope = ?", tokenHash[:], scope).First(&token).Error; err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	query := m.DB.WithContext(ctx).Where("hash = ?", token.Hash)
+	if token.Family != "" {
+		query = query.Or("family = ?", token.Family)
+	}
+
+	return query.Delete(&Token{}).Error
+}
+
+// GetAllForUser() returns the usable tokens of a scope, most recently created first
+func (m TokenModel) GetAllForUser(scope string, userID int64) ([]*Token, error) {
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tokens := []*Token{}
+
+	if err := m.DB.
+		WithContext(ctx).
+		Where("scope = ? AND user_id = ? AND expiry > ? AND used_at IS NULL", scope, userID, time.Now()).
+		Order("created_at DESC").
+		Find(&tokens).
+		Error; err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// DeleteFamily() revokes every token of a refresh family
+func (m TokenModel) DeleteFamily(family string) error {
+	if family == "" {
+		return ErrRecordNotFound
+	}
+
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result := m.DB.WithContext(ctx).Where("family = ?", family).Delete(&Token{})
+	if err := result.Error; err != nil {
+		return err
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Touch() records that a token has just been used. To avoid a write on every request
+// the timestamp is only refreshed when it is older than a minute.
+func (m TokenModel) Touch(tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.
+		WithContext(ctx).
+		Model(&Token{}).
+		Where("hash = ? AND (last_used_at IS NULL OR last_used_at < ?)", tokenHash[:], time.Now().Add(-time.Minute)).
+		Update("last_used_at", time.Now()).
+		Error
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/sha256"
 	"errors"
+	"fmt"
 	"strings"
 	"time"
 
@@ -26,6 +27,19 @@ type User struct {
 	Password  password  `json:"-" gorm:"column:password_hash"`
 	Activated bool      `json:"activated" gorm:"column:activated"`
 	Version   *int      `json:"-" gorm:"column:version;default:1"`
+
+	TOTPSecret   []byte `json:"-" gorm:"column:totp_secret"`                   // secret shared with the authenticator app, set once enrollment starts
+	TOTPEnabled  bool   `json:"two_factor_enabled" gorm:"column:totp_enabled"` // true once enrollment was confirmed with a valid code
+	TOTPLastStep int64  `json:"-" gorm:"column:totp_last_step"`                // last time step a code was accepted for, to refuse replays
+
+	FailedLogins int        `json:"-" gorm:"column:failed_logins"` // consecutive failed logins since the last successful one
+	LockedUntil  *time.Time `json:"-" gorm:"column:locked_until"`  // end of the current lockout
+
+	AdminLockedUntil *time.Time `json:"-" gorm:"column:admin_locked_until"` // end of the lock put by an administrator
+
+	PendingEmail *string `json:"pending_email,omitempty" gorm:"column:pending_email"` // new address waiting to be confirmed
+
+	DeletedAt *time.Time `json:"deleted_at,omitempty" gorm:"column:deleted_at"` // set when the user asked for the account to be deleted
 }
 
 func (u *User) IsAnonymous() bool {
@@ -34,6 +48,17 @@ func (u *User) IsAnonymous() bool {
 
 func (User) TableName() string { return "users" }
 
+// IsLocked() reports whether logins to the account are refused because of a lockout
+func (u *User) IsLocked() bool {
+	return u.LockedUntil != nil && u.LockedUntil.After(time.Now())
+}
+
+// IsAdminLocked() reports whether an administrator locked the account, which unlike a lockout
+// also refuses the sessions and API keys the user already has
+func (u *User) IsAdminLocked() bool {
+	return u.AdminLockedUntil != nil && u.AdminLockedUntil.After(time.Now())
+}
+
 func ValidateEmail(v *validator.Validator, email string) {
 	v.Check(email != "", "email", "must be provided")
 	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be a valid email address")
@@ -42,20 +67,26 @@ func ValidateEmail(v *validator.Validator, email string) {
 func ValidatePasswordPlaintext(v *validator.Validator, password string) {
 	v.Check(password != "", "password", "must be provided")
 	v.Check(len(password) >= 8, "password", "must be at least 8 bytes long")
-	v.Check(len(password) <= 72, "password", "must not be more than 72 bytes long")
+	v.Check(len(password) <= passwordHasher.MaxLength(), "password", fmt.Sprintf("must not be more than %d bytes long", passwordHasher.MaxLength()))
 }
 
-func ValidateUser(v *validator.Validator, user *User) {
+// ValidateUser() checks the user, the error returned comes from ValidatePasswordPolicy()
+func ValidateUser(v *validator.Validator, user *User) error {
 	v.Check(user.Name != "", "name", "must be provided")
 	v.Check(len(user.Name) <= 500, "name", "must not be more than 500 bytes long")
 
 	ValidateEmail(v, user.Email)
 	if user.Password.plaintext != nil {
 		ValidatePasswordPlaintext(v, *user.Password.plaintext)
+		if err := ValidatePasswordPolicy(v, *user.Password.plaintext, user.Name, user.Email); err != nil {
+			return err
+		}
 	}
 	if user.Password.hash == nil {
 		panic("missing password hash for user")
 	}
+
+	return nil
 }
 
 type UserModel struct {
@@ -80,6 +111,27 @@ func (m UserModel) Insert(user *User) error {
 	return nil
 }
 
+func (m UserModel) Get(id int64) (*User, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	var user User
+
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := m.DB.WithContext(ctx).Where("id = ?", id).First(&user).Error; err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &user, nil
+}
+
 func (m UserModel) GetByEmail(email string) (*User, error) {
 	var user User
 
@@ -105,31 +157,279 @@ func (m UserModel) Update(user *User) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	// at condition on "version" field to avoid data race existing
+	// at condition on "version" field to avoid data race existing, columns maintained
+	// by their own atomic updates are left alone so a stale copy can't overwrite them
 	result := m.DB.
 		WithContext(ctx).
 		Where("version = ?", *user.Version-1).
+		Omit("TOTPSecret", "TOTPEnabled", "TOTPLastStep", "FailedLogins", "LockedUntil", "AdminLockedUntil", "PendingEmail", "DeletedAt").
 		Updates(user)
 
 	if err := result.Error; err != nil {
-		var perr *pgconn.PgError
-		switch {
-		case errors.As(err, &perr):
-			if perr.Code == "23505" && strings.Contains(perr.Message, "users_email_key") {
-				return ErrDuplicateEmail
-			}
-		default:
-			return err
-		}
+		return userError(err)
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	return nil
+}
+
+// SetPendingEmail() records the address the user wants to switch to until it is confirmed
+func (m UserModel) SetPendingEmail(userID int64, email string) error {
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.
+		WithContext(ctx).
+		Model(&User{}).
+		Where("id = ?", userID).
+		Update("pending_email", email).
+		Error
+}
+
+// ConfirmPendingEmail() makes the pending address the email of the user. It fails with
+// ErrEditConflict when the pending address changed since the user was read, and with
+// ErrDuplicateEmail when another account took the address in the meantime.
+func (m UserModel) ConfirmPendingEmail(user *User) error {
+	if user.PendingEmail == nil {
+		return ErrEditConflict
+	}
+
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result := m.DB.
+		WithContext(ctx).
+		Model(&User{}).
+		Where("id = ? AND pending_email = ?", user.ID, *user.PendingEmail).
+		Updates(map[string]interface{}{
+			"email":         gorm.Expr("pending_email"),
+			"pending_email": nil,
+			"version":       gorm.Expr("version + 1"),
+		})
+
+	if err := result.Error; err != nil {
+		return userError(err)
 	}
 
 	if result.RowsAffected == 0 {
 		return ErrEditConflict
 	}
 
+	user.Email = *user.PendingEmail
+	user.PendingEmail = nil
+	*user.Version += 1
+
+	return nil
+}
+
+// ClearPendingEmail() abandons an unconfirmed email change
+func (m UserModel) ClearPendingEmail(userID int64) error {
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.
+		WithContext(ctx).
+		Model(&User{}).
+		Where("id = ?", userID).
+		Update("pending_email", nil).
+		Error
+}
+
+// SoftDelete() marks the account as deleted, it is removed for good by PurgeDeleted()
+// unless it is restored before then
+func (m UserModel) SoftDelete(userID int64) error {
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.
+		WithContext(ctx).
+		Model(&User{}).
+		Where("id = ? AND deleted_at IS NULL", userID).
+		Update("deleted_at", time.Now()).
+		Error
+}
+
+// Restore() cancels a pending deletion of the account
+func (m UserModel) Restore(user *User) error {
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := m.DB.
+		WithContext(ctx).
+		Model(&User{}).
+		Where("id = ?", user.ID).
+		Update("deleted_at", nil).
+		Error; err != nil {
+		return err
+	}
+
+	user.DeletedAt = nil
 	return nil
 }
 
+// PurgeDeleted() permanently deletes the accounts marked as deleted before the cutoff,
+// their tokens, permissions and everything else they own go with them through cascades
+func (m UserModel) PurgeDeleted(cutoff time.Time) (int64, error) {
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result := m.DB.WithContext(ctx).Where("deleted_at < ?", cutoff).Delete(&User{})
+
+	return result.RowsAffected, result.Error
+}
+
+// UserQuery holds the optional filters of UserModel.GetAll(), zero values don't filter
+type UserQuery struct {
+	Email         string // case-insensitive substring of the address
+	Activated     *bool
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+}
+
+func (m UserModel) GetAll(query UserQuery, filters Filters) ([]*User, Metadata, error) {
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	users := []*User{}
+	var totalRecords int64
+
+	db := m.DB.WithContext(ctx).Model(&User{})
+
+	if query.Email != "" {
+		db = db.Where("email ILIKE '%' || ? || '%'", EscapeLike(query.Email))
+	}
+	if query.Activated != nil {
+		db = db.Where("activated = ?", *query.Activated)
+	}
+	if query.CreatedAfter != nil {
+		db = db.Where("created_at >= ?", *query.CreatedAfter)
+	}
+	if query.CreatedBefore != nil {
+		db = db.Where("created_at < ?", *query.CreatedBefore)
+	}
+
+	if err := db.
+		Count(&totalRecords).
+		Order(fmt.Sprintf("%s %s, id ASC", filters.sortColumn(), filters.sortDirection())).
+		Limit(filters.limit()).
+		Offset(filters.offset()).
+		Find(&users).
+		Error; err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := CalculateMetadata(int(totalRecords), filters.Page, filters.PageSize)
+
+	return users, metadata, nil
+}
+
+// SetActivated() activates or deactivates the account, Update() can't be used to
+// deactivate because it skips fields holding their zero value
+func (m UserModel) SetActivated(user *User, activated bool) error {
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := m.DB.
+		WithContext(ctx).
+		Model(&User{}).
+		Where("id = ?", user.ID).
+		Updates(map[string]interface{}{"activated": activated, "version": gorm.Expr("version + 1")}).
+		Error; err != nil {
+		return err
+	}
+
+	user.Activated = activated
+	*user.Version += 1
+	return nil
+}
+
+// Lock() refuses logins and every other request of the user until the given time. The lock
+// is only lifted by Unlock() or when it expires.
+func (m UserModel) Lock(user *User, until time.Time) error {
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := m.DB.
+		WithContext(ctx).
+		Model(&User{}).
+		Where("id = ?", user.ID).
+		Update("admin_locked_until", until).
+		Error; err != nil {
+		return err
+	}
+
+	user.AdminLockedUntil = &until
+	return nil
+}
+
+// Unlock() lifts the lock put by an administrator
+func (m UserModel) Unlock(user *User) error {
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := m.DB.
+		WithContext(ctx).
+		Model(&User{}).
+		Where("id = ?", user.ID).
+		Update("admin_locked_until", nil).
+		Error; err != nil {
+		return err
+	}
+
+	user.AdminLockedUntil = nil
+	return nil
+}
+
+// GetAccessState() loads only the fields deciding whether the user may still make requests,
+// the deletion request and the lock put by an administrator, for requests authenticated
+// without loading the user
+func (m UserModel) GetAccessState(userID int64) (*User, error) {
+	var user User
+
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := m.DB.
+		WithContext(ctx).
+		Select("id", "deleted_at", "admin_locked_until").
+		Where("id = ?", userID).
+		First(&user).
+		Error; err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+// userError() maps the unique violation on the email column to ErrDuplicateEmail
+func userError(err error) error {
+	var perr *pgconn.PgError
+	if errors.As(err, &perr) && perr.Code == "23505" && strings.Contains(perr.Message, "users_email_key") {
+		return ErrDuplicateEmail
+	}
+
+	return err
+}
+
 func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
 	// calculate the SHA-256 hash of the plaintext token provided by the client
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
@@ -156,3 +456,72 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 
 	return &user, nil
 }
+
+// SetTOTPSecret() starts a two-factor enrollment, the secret is only enforced once EnableTOTP() is called
+func (m UserModel) SetTOTPSecret(userID int64, secret []byte) error {
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.
+		WithContext(ctx).
+		Model(&User{}).
+		Where("id = ? AND totp_enabled = false", userID).
+		Updates(map[string]interface{}{"totp_secret": secret, "totp_last_step": 0}).
+		Error
+}
+
+func (m UserModel) EnableTOTP(userID int64) error {
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.WithContext(ctx).Model(&User{}).Where("id = ?", userID).Update("totp_enabled", true).Error
+}
+
+// UseTOTPStep() records the time step of an accepted code. It reports false when the step,
+// or a later one, was already used so that an intercepted code can't be replayed.
+func (m UserModel) UseTOTPStep(userID int64, step int64) (bool, error) {
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result := m.DB.
+		WithContext(ctx).
+		Model(&User{}).
+		Where("id = ? AND totp_last_step < ?", userID, step).
+		Update("totp_last_step", step)
+
+	if err := result.Error; err != nil {
+		return false, err
+	}
+
+	return result.RowsAffected == 1, nil
+}
+
+// UpgradePassword() rehashes the password of the user with the configured hasher when the stored
+// hash is outdated. It must be called with the plaintext password which was just verified.
+func (m UserModel) UpgradePassword(user *User, plaintextPassword string) error {
+	if !user.Password.NeedsRehash() {
+		return nil
+	}
+
+	oldHash := user.Password.hash
+
+	err := user.Password.Set(plaintextPassword)
+	if err != nil {
+		return err
+	}
+
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// only replace the exact hash which was verified, a concurrent password change wins
+	return m.DB.
+		WithContext(ctx).
+		Model(&User{}).
+		Where("id = ? AND password_hash = ?", user.ID, oldHash).
+		Update("password_hash", user.Password.hash).
+		Error
+}
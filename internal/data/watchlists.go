@@ -0,0 +1,307 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lib/pq"
+	"github.com/nhan10132020/greenlight/internal/validator"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrDuplicateWatchlistItem = errors.New("duplicate watchlist item")
+	ErrWatchlistMismatch      = errors.New("watchlist mismatch")
+)
+
+// WatchlistItem is a movie a user plans to see
+type WatchlistItem struct {
+	UserID   int64     `json:"-" gorm:"column:user_id"`
+	MovieID  int64     `json:"movie_id" gorm:"column:movie_id"`
+	Position int32     `json:"position" gorm:"column:position"` // the watchlist is ordered by position, lowest first
+	AddedAt  time.Time `json:"added_at" gorm:"column:added_at"`
+	Movie    *Movie    `json:"movie,omitempty" gorm:"foreignKey:MovieID"`
+}
+
+func (WatchlistItem) TableName() string { return "watchlist_items" }
+
+type WatchlistModel struct {
+	DB *gorm.DB
+}
+
+// Insert() appends the movie to the end of the user's watchlist
+func (m WatchlistModel) Insert(item *WatchlistItem) error {
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// concurrent additions would read the same last position, they are serialized on the user
+		if err := lockWatchlist(tx, item.UserID); err != nil {
+			return err
+		}
+
+		return tx.
+			Raw(`INSERT INTO watchlist_items (user_id, movie_id, position)
+				SELECT ?, ?, COALESCE(MAX(position), 0) + 1 FROM watchlist_items WHERE user_id = ?
+				RETURNING position, added_at`, item.UserID, item.MovieID, item.UserID).
+			Row().
+			Scan(&item.Position, &item.AddedAt)
+	})
+	if err != nil {
+		var perr *pgconn.PgError
+		if errors.As(err, &perr) {
+			switch perr.Code {
+			case "23505":
+				return ErrDuplicateWatchlistItem
+			case "23503":
+				// the movie doesn't exist, or was deleted in the meantime
+				return ErrRecordNotFound
+			}
+		}
+		return err
+	}
+
+	return nil
+}
+
+// lockWatchlist() locks the user's row until the end of the transaction, as row locks on the
+// watchlist items themselves wouldn't stop new items from being inserted
+func lockWatchlist(tx *gorm.DB, userID int64) error {
+	var ids []int64
+
+	return tx.
+		Model(&User{}).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ?", userID).
+		Pluck("id", &ids).
+		Error
+}
+
+// GetAllForUser() returns a page of the user's watchlist in order, with the movies
+func (m WatchlistModel) GetAllForUser(userID int64, filters Filters) ([]*WatchlistItem, Metadata, error) {
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	items := []*WatchlistItem{}
+	var totalRecords int64
+
+	if err := m.DB.
+		WithContext(ctx).
+		Model(&WatchlistItem{}).
+		Where("user_id = ?", userID).
+		Count(&totalRecords).
+		Preload("Movie").
+		Order("position ASC, added_at ASC").
+		Limit(filters.limit()).
+		Offset(filters.offset()).
+		Find(&items).
+		Error; err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return items, CalculateMetadata(int(totalRecords), filters.Page, filters.PageSize), nil
+}
+
+// ExportForUser() returns the whole watchlist of the user in order, with the movies
+func (m WatchlistModel) ExportForUser(userID int64) ([]*WatchlistItem, error) {
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	items := []*WatchlistItem{}
+
+	if err := m.DB.
+		WithContext(ctx).
+		Where("user_id = ?", userID).
+		Preload("Movie").
+		Order("position ASC, added_at ASC").
+		Find(&items).
+		Error; err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// Reorder() gives the watchlist the order of movieIDs, which must list every movie of it
+// exactly once
+func (m WatchlistModel) Reorder(userID int64, movieIDs []int64) error {
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// lock the watchlist so that a movie added concurrently can't be left out of the order
+		if err := lockWatchlist(tx, userID); err != nil {
+			return err
+		}
+
+		var current []int64
+
+		if err := tx.
+			Model(&WatchlistItem{}).
+			Where("user_id = ?", userID).
+			Pluck("movie_id", &current).
+			Error; err != nil {
+			return err
+		}
+
+		if len(current) != len(movieIDs) {
+			return ErrWatchlistMismatch
+		}
+
+		listed := make(map[int64]bool, len(movieIDs))
+		for _, id := range movieIDs {
+			listed[id] = true
+		}
+		for _, id := range current {
+			if !listed[id] {
+				return ErrWatchlistMismatch
+			}
+		}
+
+		return tx.
+			Exec(`UPDATE watchlist_items SET position = ordered.position
+				FROM unnest(?::bigint[]) WITH ORDINALITY AS ordered(movie_id, position)
+				WHERE watchlist_items.user_id = ? AND watchlist_items.movie_id = ordered.movie_id`, pq.Int64Array(movieIDs), userID).
+			Error
+	})
+}
+
+func (m WatchlistModel) Delete(userID, movieID int64) error {
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result := m.DB.WithContext(ctx).Where("user_id = ? AND movie_id = ?", userID, movieID).Delete(&WatchlistItem{})
+
+	if err := result.Error; err != nil {
+		return err
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Viewing is one entry of a user's log of watched movies
+type Viewing struct {
+	ID        int64     `json:"id" gorm:"column:id"`
+	CreatedAt time.Time `json:"created_at" gorm:"column:created_at"`
+	UserID    int64     `json:"-" gorm:"column:user_id"`
+	MovieID   int64     `json:"movie_id" gorm:"column:movie_id"`
+	WatchedOn time.Time `json:"watched_on" gorm:"column:watched_on;type:date"`
+	Rating    *int32    `json:"rating" gorm:"column:rating"` // personal rating from 1 to 10, separate from reviews
+	Movie     *Movie    `json:"movie,omitempty" gorm:"foreignKey:MovieID"`
+}
+
+func (Viewing) TableName() string { return "watched_movies" }
+
+func ValidateViewing(v *validator.Validator, viewing *Viewing) {
+	v.Check(!viewing.WatchedOn.IsZero(), "watched_on", "must be provided")
+	v.Check(viewing.WatchedOn.Year() >= 1888, "watched_on", "must not be before 1888")
+	v.Check(!viewing.WatchedOn.After(time.Now()), "watched_on", "must not be in the future")
+	if viewing.Rating != nil {
+		v.Check(*viewing.Rating >= 1 && *viewing.Rating <= 10, "rating", "must be between 1 and 10")
+	}
+}
+
+type ViewingModel struct {
+	DB *gorm.DB
+}
+
+func (m ViewingModel) Insert(viewing *Viewing) error {
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := m.DB.WithContext(ctx).Omit("ID", "CreatedAt", "Movie").Create(viewing).Error; err != nil {
+		var perr *pgconn.PgError
+		if errors.As(err, &perr) && perr.Code == "23503" {
+			// the movie was deleted in the meantime
+			return ErrRecordNotFound
+		}
+		return err
+	}
+
+	return nil
+}
+
+// GetAllForUser() returns a page of the user's log of watched movies, with the movies
+func (m ViewingModel) GetAllForUser(userID int64, filters Filters) ([]*Viewing, Metadata, error) {
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	viewings := []*Viewing{}
+	var totalRecords int64
+
+	if err := m.DB.
+		WithContext(ctx).
+		Model(&Viewing{}).
+		Where("user_id = ?", userID).
+		Count(&totalRecords).
+		Preload("Movie").
+		Order(fmt.Sprintf("%s %s, id DESC", filters.sortColumn(), filters.sortDirection())).
+		Limit(filters.limit()).
+		Offset(filters.offset()).
+		Find(&viewings).
+		Error; err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return viewings, CalculateMetadata(int(totalRecords), filters.Page, filters.PageSize), nil
+}
+
+// ExportForUser() returns the whole log of watched movies of the user, most recent first,
+// with the movies
+func (m ViewingModel) ExportForUser(userID int64) ([]*Viewing, error) {
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	viewings := []*Viewing{}
+
+	if err := m.DB.
+		WithContext(ctx).
+		Where("user_id = ?", userID).
+		Preload("Movie").
+		Order("watched_on DESC, id DESC").
+		Find(&viewings).
+		Error; err != nil {
+		return nil, err
+	}
+
+	return viewings, nil
+}
+
+// Delete() removes an entry of the user's log, entries of other users are not found
+func (m ViewingModel) Delete(userID, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	// context 3-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result := m.DB.WithContext(ctx).Where("user_id = ?", userID).Delete(&Viewing{}, id)
+
+	if err := result.Error; err != nil {
+		return err
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
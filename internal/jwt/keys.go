@@ -0,0 +1,142 @@
+package jwt
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmEdDSA = "EdDSA"
+	AlgorithmRS256 = "RS256" // verify-only, used by identity providers
+	AlgorithmES256 = "ES256" // verify-only, used by identity providers
+)
+
+// Key is a named key used to sign or verify tokens
+type Key struct {
+	ID        string
+	Algorithm string
+
+	secret  []byte             // HS256 shared secret
+	private ed25519.PrivateKey // EdDSA private key, nil for a verify-only key
+	public  ed25519.PublicKey  // EdDSA public key
+
+	rsaPublic   *rsa.PublicKey   // RS256 public key
+	ecdsaPublic *ecdsa.PublicKey // ES256 public key
+}
+
+// NewPublicKey() returns a verify-only key for a public key published by someone else, such
+// as an identity provider in its JWKS document
+func NewPublicKey(id, algorithm string, public crypto.PublicKey) (*Key, error) {
+	key := &Key{ID: id, Algorithm: algorithm}
+
+	var ok bool
+	switch algorithm {
+	case AlgorithmEdDSA:
+		key.public, ok = public.(ed25519.PublicKey)
+	case AlgorithmRS256:
+		key.rsaPublic, ok = public.(*rsa.PublicKey)
+		ok = ok && key.rsaPublic.N.BitLen() >= 2048
+	case AlgorithmES256:
+		key.ecdsaPublic, ok = public.(*ecdsa.PublicKey)
+		ok = ok && key.ecdsaPublic.Curve == elliptic.P256()
+	}
+	if !ok {
+		return nil, fmt.Errorf("jwt: unsupported %s key %q", algorithm, id)
+	}
+
+	return key, nil
+}
+
+// LoadKeyFile() reads a key from disk. A PEM encoded PKCS #8 Ed25519 private key or PKIX
+// public key is used for EdDSA, anything else is treated as a raw HS256 secret.
+func LoadKeyFile(id, path string) (*Key, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(content)
+	if block == nil {
+		secret := bytes.TrimSpace(content)
+		if len(secret) < 32 {
+			return nil, fmt.Errorf("jwt: HS256 secret in %s must be at least 32 bytes long", path)
+		}
+		return &Key{ID: id, Algorithm: AlgorithmHS256, secret: secret}, nil
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		private, ok := parsed.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("jwt: private key in %s is not an Ed25519 key", path)
+		}
+		return &Key{ID: id, Algorithm: AlgorithmEdDSA, private: private, public: private.Public().(ed25519.PublicKey)}, nil
+	case "PUBLIC KEY":
+		parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		public, ok := parsed.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("jwt: public key in %s is not an Ed25519 key", path)
+		}
+		return &Key{ID: id, Algorithm: AlgorithmEdDSA, public: public}, nil
+	default:
+		return nil, fmt.Errorf("jwt: unsupported PEM block %q in %s", block.Type, path)
+	}
+}
+
+// KeySet holds every key accepted for verification and the single key used for signing.
+// Rotating keys means adding the new key, making it the signing key, and removing the old
+// key once the tokens it signed have expired.
+type KeySet struct {
+	keys    map[string]*Key
+	signing *Key
+}
+
+func NewKeySet(signingKeyID string, keys ...*Key) (*KeySet, error) {
+	s := &KeySet{keys: make(map[string]*Key)}
+
+	for _, key := range keys {
+		if _, exists := s.keys[key.ID]; exists {
+			return nil, fmt.Errorf("jwt: duplicate key id %q", key.ID)
+		}
+		s.keys[key.ID] = key
+	}
+
+	signing, ok := s.keys[signingKeyID]
+	if !ok {
+		return nil, fmt.Errorf("jwt: signing key %q is not in the key set", signingKeyID)
+	}
+	if !signing.canSign() {
+		return nil, errors.New("jwt: signing key " + signingKeyID + " is verify-only")
+	}
+	s.signing = signing
+
+	return s, nil
+}
+
+// NewVerifyingKeySet() returns a key set which verifies tokens signed by the keys but can't sign
+func NewVerifyingKeySet(keys ...*Key) *KeySet {
+	s := &KeySet{keys: make(map[string]*Key)}
+
+	for _, key := range keys {
+		s.keys[key.ID] = key
+	}
+
+	return s
+}
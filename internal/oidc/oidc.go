@@ -0,0 +1,210 @@
+// Package oidc implements the relying party side of the OpenID Connect authorization code
+// flow with PKCE (RFC 7636): discovery of the provider endpoints, the authorization URL, the
+// exchange of the code for an ID token and its verification against the provider's JWKS.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nhan10132020/greenlight/internal/jwt"
+)
+
+var (
+	ErrInvalidGrant   = errors.New("oidc: invalid or expired authorization code")
+	ErrInvalidIDToken = errors.New("oidc: invalid ID token")
+)
+
+// JWKS documents are fetched again at most this often, when a token names an unknown key
+const jwksRefreshInterval = time.Minute
+
+// responses of the provider larger than this are refused
+const maxResponseSize = 1 << 20
+
+type Config struct {
+	Issuer       string // exact issuer identifier, discovery is done relative to it
+	ClientID     string
+	ClientSecret string // empty for a public client, which relies on PKCE alone
+	RedirectURL  string // where the provider sends the user back with the code
+}
+
+// endpoints are the parts of the discovery document the flow needs
+type endpoints struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	CodeChallengeMethods  []string `json:"code_challenge_methods_supported"`
+}
+
+// Provider is an identity provider the API delegates logins to
+type Provider struct {
+	config    Config
+	endpoints endpoints
+	client    *http.Client
+
+	mu      sync.Mutex
+	keys    *jwt.KeySet
+	fetched time.Time
+}
+
+// Discover() reads the discovery document of the issuer
+func Discover(ctx context.Context, config Config) (*Provider, error) {
+	p := &Provider{
+		config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+
+	err := p.getJSON(ctx, strings.TrimSuffix(config.Issuer, "/")+"/.well-known/openid-configuration", &p.endpoints)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case p.endpoints.Issuer != config.Issuer:
+		return nil, fmt.Errorf("oidc: discovery document is for issuer %q, not %q", p.endpoints.Issuer, config.Issuer)
+	case p.endpoints.AuthorizationEndpoint == "" || p.endpoints.TokenEndpoint == "" || p.endpoints.JWKSURI == "":
+		return nil, errors.New("oidc: discovery document is missing an endpoint")
+	case p.endpoints.CodeChallengeMethods != nil && !contains(p.endpoints.CodeChallengeMethods, "S256"):
+		return nil, errors.New("oidc: provider doesn't support S256 PKCE challenges")
+	}
+
+	return p, nil
+}
+
+// NewVerifier() returns a random PKCE code verifier, and NewNonce() a random nonce, both
+// with 256 bits of entropy
+func NewVerifier() (string, error) {
+	b := make([]byte, 32)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func NewNonce() (string, error) {
+	return NewVerifier()
+}
+
+// AuthCodeURL() returns the URL of the provider the user logs in at. state comes back with
+// the code, and the verifier and nonce must be kept to exchange it.
+func (p *Provider) AuthCodeURL(state, nonce, verifier string) string {
+	challenge := sha256.Sum256([]byte(verifier))
+
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {"openid email profile"},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(p.endpoints.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	return p.endpoints.AuthorizationEndpoint + separator + params.Encode()
+}
+
+// Exchange() trades the authorization code for an ID token and returns its verified claims
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (*Claims, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"client_id":     {p.config.ClientID},
+		"code_verifier": {verifier},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoints.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+
+	err = json.NewDecoder(io.LimitReader(res.Body, maxResponseSize)).Decode(&body)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: token response: %w", err)
+	}
+
+	switch {
+	case body.Error == "invalid_grant":
+		return nil, ErrInvalidGrant
+	case body.Error != "":
+		return nil, fmt.Errorf("oidc: token endpoint: %s %s", body.Error, body.ErrorDescription)
+	case res.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("oidc: token endpoint: unexpected status %d", res.StatusCode)
+	case body.IDToken == "":
+		return nil, errors.New("oidc: token response has no ID token")
+	}
+
+	return p.Verify(ctx, body.IDToken, nonce)
+}
+
+func (p *Provider) getJSON(ctx context.Context, url string, dst interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: GET %s: unexpected status %d", url, res.StatusCode)
+	}
+
+	err = json.NewDecoder(io.LimitReader(res.Body, maxResponseSize)).Decode(dst)
+	if err != nil {
+		return fmt.Errorf("oidc: GET %s: %w", url, err)
+	}
+
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,237 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// stubIdP is a local identity provider serving discovery, token and JWKS endpoints. The token
+// endpoint answers with an ID token built from the claims set by the test.
+type stubIdP struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	claims map[string]interface{}
+
+	// what the token endpoint received
+	code, verifier, clientID string
+}
+
+func newStubIdP(t *testing.T) *stubIdP {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idp := &stubIdP{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{
+			"issuer":                           idp.URL,
+			"authorization_endpoint":           idp.URL + "/authorize",
+			"token_endpoint":                   idp.URL + "/token",
+			"jwks_uri":                         idp.URL + "/jwks",
+			"code_challenge_methods_supported": []string{"S256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{
+			"keys": []map[string]string{{
+				"kid": "stub",
+				"kty": "RSA",
+				"use": "sig",
+				"alg": "RS256",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		idp.code = r.PostFormValue("code")
+		idp.verifier = r.PostFormValue("code_verifier")
+		idp.clientID = r.PostFormValue("client_id")
+
+		if idp.code != "good-code" {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		writeJSON(w, map[string]string{"id_token": idp.sign(t, idp.claims)})
+	})
+
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+
+	return idp
+}
+
+// sign() returns an RS256 ID token carrying the claims
+func (idp *stubIdP) sign(t *testing.T, claims map[string]interface{}) string {
+	t.Helper()
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": "stub"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(input))
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// validClaims() returns the claims of an ID token the provider must accept
+func (idp *stubIdP) validClaims(nonce string) map[string]interface{} {
+	now := time.Now().Unix()
+
+	return map[string]interface{}{
+		"iss":            idp.URL,
+		"sub":            "user-1",
+		"aud":            "greenlight",
+		"nonce":          nonce,
+		"exp":            now + 300,
+		"iat":            now,
+		"email":          "alice@example.com",
+		"email_verified": true,
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func discover(t *testing.T, idp *stubIdP) *Provider {
+	t.Helper()
+
+	p, err := Discover(context.Background(), Config{
+		Issuer:      idp.URL,
+		ClientID:    "greenlight",
+		RedirectURL: "http://localhost/callback",
+	})
+	if err != nil {
+		t.Fatalf("Discover() = %v", err)
+	}
+
+	return p
+}
+
+func TestDiscoverRejectsIssuerMismatch(t *testing.T) {
+	idp := newStubIdP(t)
+
+	_, err := Discover(context.Background(), Config{Issuer: idp.URL + "/other", ClientID: "greenlight"})
+	if err == nil {
+		t.Fatal("Discover() accepted a discovery document for another issuer")
+	}
+}
+
+func TestExchange(t *testing.T) {
+	idp := newStubIdP(t)
+	p := discover(t, idp)
+
+	idp.claims = idp.validClaims("nonce-1")
+
+	claims, err := p.Exchange(context.Background(), "good-code", "verifier-1", "nonce-1")
+	if err != nil {
+		t.Fatalf("Exchange() = %v", err)
+	}
+
+	if claims.Subject != "user-1" || claims.Email != "alice@example.com" || !claims.EmailVerified {
+		t.Errorf("Exchange() claims = %+v", claims)
+	}
+	if idp.code != "good-code" || idp.verifier != "verifier-1" || idp.clientID != "greenlight" {
+		t.Errorf("token endpoint got code %q, verifier %q, client %q", idp.code, idp.verifier, idp.clientID)
+	}
+}
+
+func TestExchangeInvalidGrant(t *testing.T) {
+	idp := newStubIdP(t)
+	p := discover(t, idp)
+
+	_, err := p.Exchange(context.Background(), "bad-code", "verifier-1", "nonce-1")
+	if !errors.Is(err, ErrInvalidGrant) {
+		t.Fatalf("Exchange() = %v, want ErrInvalidGrant", err)
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	idp := newStubIdP(t)
+	p := discover(t, idp)
+
+	tests := []struct {
+		name   string
+		change func(claims map[string]interface{})
+	}{
+		{"nonce mismatch", func(c map[string]interface{}) { c["nonce"] = "other-nonce" }},
+		{"other audience", func(c map[string]interface{}) { c["aud"] = "other-client" }},
+		{"several audiences without azp", func(c map[string]interface{}) { c["aud"] = []string{"greenlight", "other-client"} }},
+		{"azp of another client", func(c map[string]interface{}) {
+			c["aud"] = []string{"greenlight", "other-client"}
+			c["azp"] = "other-client"
+		}},
+		{"other issuer", func(c map[string]interface{}) { c["iss"] = "https://attacker.example.com" }},
+		{"missing subject", func(c map[string]interface{}) { delete(c, "sub") }},
+		{"missing exp", func(c map[string]interface{}) { delete(c, "exp") }},
+		{"missing iat", func(c map[string]interface{}) { delete(c, "iat") }},
+		{"expired", func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Minute).Unix() }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := idp.validClaims("nonce-1")
+			tt.change(claims)
+
+			_, err := p.Verify(context.Background(), idp.sign(t, claims), "nonce-1")
+			if !errors.Is(err, ErrInvalidIDToken) {
+				t.Fatalf("Verify() = %v, want ErrInvalidIDToken", err)
+			}
+		})
+	}
+}
+
+func TestVerifyAcceptsAuthorizedParty(t *testing.T) {
+	idp := newStubIdP(t)
+	p := discover(t, idp)
+
+	claims := idp.validClaims("nonce-1")
+	claims["aud"] = []string{"greenlight", "other-client"}
+	claims["azp"] = "greenlight"
+
+	_, err := p.Verify(context.Background(), idp.sign(t, claims), "nonce-1")
+	if err != nil {
+		t.Fatalf("Verify() = %v", err)
+	}
+}
+
+func TestVerifyRejectsForeignSignature(t *testing.T) {
+	idp := newStubIdP(t)
+	p := discover(t, idp)
+
+	// same key id, different key
+	other := newStubIdP(t)
+
+	_, err := p.Verify(context.Background(), other.sign(t, idp.validClaims("nonce-1")), "nonce-1")
+	if !errors.Is(err, ErrInvalidIDToken) {
+		t.Fatalf("Verify() = %v, want ErrInvalidIDToken", err)
+	}
+}
@@ -0,0 +1,202 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/nhan10132020/greenlight/internal/jwt"
+)
+
+// Claims are the claims of a verified ID token the API uses
+type Claims struct {
+	Issuer          string   `json:"iss"`
+	Subject         string   `json:"sub"` // stable identifier of the user at the issuer
+	Audience        audience `json:"aud"`
+	AuthorizedParty string   `json:"azp"`
+	Nonce           string   `json:"nonce"`
+	Expiry          *int64   `json:"exp"` // required, checked against the clock by the jwt package
+	IssuedAt        *int64   `json:"iat"` // required
+	Email           string   `json:"email"`
+	EmailVerified   bool     `json:"email_verified"`
+	Name            string   `json:"name"`
+}
+
+// audience is a single string or an array of strings
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+// Verify() checks the signature of an ID token against the provider's keys, its expiry,
+// issuer, audience and nonce, and returns its claims. Unlike the API's own tokens, ID tokens
+// must carry exp and iat.
+func (p *Provider) Verify(ctx context.Context, rawIDToken, nonce string) (*Claims, error) {
+	keys, err := p.keySet(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	err = keys.Verify(rawIDToken, &claims)
+
+	// the provider may have rotated its keys since they were fetched
+	if errors.Is(err, jwt.ErrUnknownKey) {
+		keys, err = p.keySet(ctx, true)
+		if err != nil {
+			return nil, err
+		}
+		err = keys.Verify(rawIDToken, &claims)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	switch {
+	case claims.Issuer != p.config.Issuer:
+		return nil, fmt.Errorf("%w: unexpected issuer %q", ErrInvalidIDToken, claims.Issuer)
+	case !contains(claims.Audience, p.config.ClientID):
+		return nil, fmt.Errorf("%w: not issued for this client", ErrInvalidIDToken)
+	case len(claims.Audience) > 1 && claims.AuthorizedParty != p.config.ClientID:
+		return nil, fmt.Errorf("%w: not authorized for this client", ErrInvalidIDToken)
+	case claims.Nonce != nonce:
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: missing subject", ErrInvalidIDToken)
+	case claims.Expiry == nil || claims.IssuedAt == nil:
+		// optional for the API's own tokens, but an ID token without them would never expire
+		return nil, fmt.Errorf("%w: missing exp or iat", ErrInvalidIDToken)
+	}
+
+	return &claims, nil
+}
+
+// keySet() returns the provider's keys, fetching the JWKS document on first use and, when
+// refresh is set, again if the last fetch is old enough
+func (p *Provider) keySet(ctx context.Context, refresh bool) (*jwt.KeySet, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.keys != nil && (!refresh || time.Since(p.fetched) < jwksRefreshInterval) {
+		return p.keys, nil
+	}
+
+	var document struct {
+		Keys []jwk `json:"keys"`
+	}
+
+	err := p.getJSON(ctx, p.endpoints.JWKSURI, &document)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]*jwt.Key, 0, len(document.Keys))
+	for _, k := range document.Keys {
+		// encryption keys and key types the API can't verify with are skipped
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		key, err := k.key()
+		if err != nil {
+			continue
+		}
+		keys = append(keys, key)
+	}
+
+	p.keys = jwt.NewVerifyingKeySet(keys...)
+	p.fetched = time.Now()
+
+	return p.keys, nil
+}
+
+// jwk is a JSON Web Key (RFC 7517) of one of the types the API verifies with
+type jwk struct {
+	KeyID     string `json:"kid"`
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv"`
+	N         string `json:"n"`
+	E         string `json:"e"`
+	X         string `json:"x"`
+	Y         string `json:"y"`
+}
+
+func (k jwk) key() (*jwt.Key, error) {
+	var (
+		algorithm string
+		public    crypto.PublicKey
+	)
+
+	switch {
+	case k.KeyType == "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(k.E)
+		if err != nil || !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("oidc: invalid RSA exponent")
+		}
+		algorithm, public = jwt.AlgorithmRS256, &rsa.PublicKey{N: n, E: int(e.Int64())}
+	case k.KeyType == "EC" && k.Curve == "P-256":
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return nil, errors.New("oidc: EC point is not on the curve")
+		}
+		algorithm, public = jwt.AlgorithmES256, &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+	case k.KeyType == "OKP" && k.Curve == "Ed25519":
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("oidc: invalid Ed25519 key")
+		}
+		algorithm, public = jwt.AlgorithmEdDSA, ed25519.PublicKey(x)
+	default:
+		return nil, fmt.Errorf("oidc: unsupported key type %q", k.KeyType)
+	}
+
+	// a key pinned to another algorithm must not be used with the one derived from its type
+	if k.Algorithm != "" && k.Algorithm != algorithm {
+		return nil, fmt.Errorf("oidc: unsupported algorithm %q", k.Algorithm)
+	}
+
+	return jwt.NewPublicKey(k.KeyID, algorithm, public)
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("oidc: invalid key parameter")
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
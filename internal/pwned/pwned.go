@@ -0,0 +1,145 @@
+// Package pwned checks passwords against a local copy of the Have I Been Pwned
+// breached-password list, in the SHA-1 format produced by the k-anonymity downloader:
+// one "HASH:COUNT" line per password, sorted by hash, with upper-case hex hashes.
+package pwned
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+// the k-anonymity API splits hashes into a 5 hex digit prefix and a 35 digit suffix,
+// the same prefix is used to index the file
+const (
+	prefixLength = 5
+	prefixCount  = 1 << (4 * prefixLength)
+	hashLength   = 2 * sha1.Size
+)
+
+var ErrUnsorted = errors.New("entries are not sorted by hash")
+
+// List is a breached-password file opened for lookups. Only the offset of the first line
+// of every hash prefix is held in memory, a lookup reads the lines of one prefix from disk.
+type List struct {
+	file    *os.File
+	offsets []int64 // offsets[p] is where lines with prefix p start, offsets[p+1] where they end
+}
+
+// Open() reads through the file once to build the prefix index
+func Open(path string) (*List, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	offsets, err := index(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("pwned: %s: %w", path, err)
+	}
+
+	return &List{file: file, offsets: offsets}, nil
+}
+
+func index(r io.Reader) ([]int64, error) {
+	offsets := make([]int64, prefixCount+1)
+
+	var (
+		offset int64
+		next   int // first prefix whose start offset is still unknown
+		line   int
+	)
+
+	reader := bufio.NewReader(r)
+	for {
+		raw, err := reader.ReadSlice('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		if len(raw) == 0 {
+			break
+		}
+		line++
+
+		text := bytes.TrimSpace(raw)
+		if len(text) > 0 {
+			if len(text) < hashLength {
+				return nil, fmt.Errorf("line %d: malformed entry", line)
+			}
+
+			prefix, perr := strconv.ParseUint(string(text[:prefixLength]), 16, 32)
+			if perr != nil {
+				return nil, fmt.Errorf("line %d: malformed entry", line)
+			}
+
+			if int(prefix)+1 < next {
+				return nil, ErrUnsorted
+			}
+
+			for ; next <= int(prefix); next++ {
+				offsets[next] = offset
+			}
+		}
+
+		offset += int64(len(raw))
+
+		if errors.Is(err, io.EOF) {
+			break
+		}
+	}
+
+	for ; next <= prefixCount; next++ {
+		offsets[next] = offset
+	}
+
+	return offsets, nil
+}
+
+// Count() returns how many times the password appeared in breaches, 0 when it never did
+func (l *List) Count(password string) (int, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := []byte(hex.EncodeToString(sum[:]))
+	hash = bytes.ToUpper(hash)
+
+	prefix, _ := strconv.ParseUint(string(hash[:prefixLength]), 16, 32)
+
+	start, end := l.offsets[prefix], l.offsets[prefix+1]
+	if start == end {
+		return 0, nil
+	}
+
+	chunk := make([]byte, end-start)
+	_, err := l.file.ReadAt(chunk, start)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return 0, err
+	}
+
+	for _, line := range bytes.Split(chunk, []byte{'\n'}) {
+		line = bytes.TrimSpace(line)
+		if len(line) < hashLength || !bytes.EqualFold(line[:hashLength], hash) {
+			continue
+		}
+
+		count := 1
+		if i := bytes.IndexByte(line, ':'); i >= 0 {
+			if n, err := strconv.Atoi(string(line[i+1:])); err == nil {
+				count = n
+			}
+		}
+
+		return count, nil
+	}
+
+	return 0, nil
+}
+
+func (l *List) Close() error {
+	return l.file.Close()
+}
@@ -54,9 +54,9 @@ func Matches(value string, rx *regexp.Regexp) bool {
 	return rx.MatchString(value)
 }
 
-// Unique() returns true if all string values in a slice are unique
-func Unique(values []string) bool {
-	uniqueValues := make(map[string]bool)
+// Unique() returns true if all values in a slice are unique
+func Unique[T comparable](values []T) bool {
+	uniqueValues := make(map[T]bool)
 	for _, value := range values {
 		uniqueValues[value] = true
 	}
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS login_failures;
+
+ALTER TABLE users DROP COLUMN IF EXISTS locked_until;
+ALTER TABLE users DROP COLUMN IF EXISTS failed_logins;
@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS users_roles;
+DROP TABLE IF EXISTS roles_permissions;
+DROP TABLE IF EXISTS roles;
+
+DELETE FROM permissions WHERE code = 'permissions:admin';
+
+ALTER TABLE permissions DROP CONSTRAINT IF EXISTS permissions_code_key;
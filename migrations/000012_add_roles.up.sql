@@ -0,0 +1,40 @@
+ALTER TABLE permissions ADD CONSTRAINT permissions_code_key UNIQUE (code);
+
+CREATE TABLE IF NOT EXISTS roles (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name text UNIQUE NOT NULL,
+    description text NOT NULL DEFAULT '',
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE TABLE IF NOT EXISTS roles_permissions (
+    role_id bigint NOT NULL REFERENCES roles ON DELETE CASCADE,
+    permission_id bigint NOT NULL REFERENCES permissions ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+);
+
+CREATE TABLE IF NOT EXISTS users_roles (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    role_id bigint NOT NULL REFERENCES roles ON DELETE CASCADE,
+    PRIMARY KEY (user_id, role_id)
+);
+
+-- Add the permission guarding the role and permission administration endpoints.
+INSERT INTO permissions (code)
+VALUES
+    ('permissions:admin')
+ON CONFLICT DO NOTHING;
+
+-- Add default roles bundling the existing permissions.
+INSERT INTO roles (name, description)
+VALUES
+    ('viewer', 'Read access to movies'),
+    ('editor', 'Read and write access to movies'),
+    ('admin', 'Manage roles and user permissions');
+
+INSERT INTO roles_permissions
+SELECT roles.id, permissions.id FROM roles, permissions
+WHERE (roles.name = 'viewer' AND permissions.code = 'movies:read')
+    OR (roles.name = 'editor' AND permissions.code IN ('movies:read', 'movies:write'))
+    OR (roles.name = 'admin' AND permissions.code = 'permissions:admin');
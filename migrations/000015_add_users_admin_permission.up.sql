@@ -0,0 +1,10 @@
+-- Add the permission guarding the user administration endpoints.
+INSERT INTO permissions (code)
+VALUES
+    ('users:admin')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO roles_permissions
+SELECT roles.id, permissions.id FROM roles, permissions
+WHERE roles.name = 'admin' AND permissions.code = 'users:admin'
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,4 @@
+DELETE FROM permissions WHERE code = 'users:impersonate';
+
+ALTER TABLE tokens DROP COLUMN IF EXISTS read_only;
+ALTER TABLE tokens DROP COLUMN IF EXISTS impersonator_id;
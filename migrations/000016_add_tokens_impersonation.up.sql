@@ -0,0 +1,13 @@
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS impersonator_id bigint REFERENCES users ON DELETE CASCADE;
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS read_only bool NOT NULL DEFAULT false;
+
+-- Add the permission guarding the impersonation endpoint.
+INSERT INTO permissions (code)
+VALUES
+    ('users:impersonate')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO roles_permissions
+SELECT roles.id, permissions.id FROM roles, permissions
+WHERE roles.name = 'admin' AND permissions.code = 'users:impersonate'
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS invites (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    created_by bigint REFERENCES users ON DELETE SET NULL,
+    hash bytea UNIQUE NOT NULL,
+    email citext,
+    expiry timestamp(0) with time zone NOT NULL,
+    max_uses integer NOT NULL DEFAULT 1,
+    uses integer NOT NULL DEFAULT 0,
+    permissions text[] NOT NULL DEFAULT '{}'
+);
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS user_identities (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    issuer text NOT NULL,
+    subject text NOT NULL,
+    email citext NOT NULL DEFAULT '',
+    UNIQUE (issuer, subject)
+);
+
+CREATE INDEX IF NOT EXISTS user_identities_user_id_idx ON user_identities (user_id);
+
+-- logins started at the identity provider, kept until the code comes back
+CREATE TABLE IF NOT EXISTS oidc_states (
+    hash bytea PRIMARY KEY,
+    code_verifier text NOT NULL,
+    nonce text NOT NULL,
+    expiry timestamp(0) with time zone NOT NULL
+);
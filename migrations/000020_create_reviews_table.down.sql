@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS reviews;
+DROP FUNCTION IF EXISTS reviews_update_movie_rating();
+
+DROP INDEX IF EXISTS movies_average_rating_idx;
+ALTER TABLE movies DROP COLUMN IF EXISTS rating_count;
+ALTER TABLE movies DROP COLUMN IF EXISTS average_rating;
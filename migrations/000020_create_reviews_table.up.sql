@@ -0,0 +1,44 @@
+CREATE TABLE IF NOT EXISTS reviews (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    rating smallint NOT NULL CHECK (rating BETWEEN 1 AND 10),
+    body text NOT NULL DEFAULT '',
+    version integer NOT NULL DEFAULT 1,
+    UNIQUE (movie_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS reviews_user_id_idx ON reviews (user_id);
+
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS average_rating double precision NOT NULL DEFAULT 0;
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS rating_count integer NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS movies_average_rating_idx ON movies (average_rating);
+
+-- the rating of a movie is kept up to date by the database, so that reviews removed
+-- by cascading deletes are accounted for as well
+CREATE OR REPLACE FUNCTION reviews_update_movie_rating() RETURNS trigger AS $$
+DECLARE
+    changed bigint;
+BEGIN
+    FOREACH changed IN ARRAY ARRAY[
+        CASE WHEN TG_OP <> 'INSERT' THEN OLD.movie_id END,
+        CASE WHEN TG_OP <> 'DELETE' THEN NEW.movie_id END
+    ] LOOP
+        CONTINUE WHEN changed IS NULL;
+
+        UPDATE movies SET
+            average_rating = COALESCE((SELECT round(avg(rating), 2) FROM reviews WHERE movie_id = changed), 0),
+            rating_count = (SELECT count(*) FROM reviews WHERE movie_id = changed)
+        WHERE id = changed;
+    END LOOP;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER reviews_update_movie_rating
+AFTER INSERT OR UPDATE OF rating, movie_id OR DELETE ON reviews
+FOR EACH ROW EXECUTE FUNCTION reviews_update_movie_rating();
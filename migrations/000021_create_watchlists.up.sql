@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS watchlist_items (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    position integer NOT NULL,
+    added_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, movie_id)
+);
+
+-- a movie can be watched more than once, every viewing is logged
+CREATE TABLE IF NOT EXISTS watched_movies (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    watched_on date NOT NULL,
+    rating smallint CHECK (rating BETWEEN 1 AND 10)
+);
+
+CREATE INDEX IF NOT EXISTS watched_movies_user_id_movie_id_idx ON watched_movies (user_id, movie_id);
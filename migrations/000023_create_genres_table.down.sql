@@ -0,0 +1,3 @@
+-- the genres of movies stay normalized, the original values are not restored
+DROP TABLE IF EXISTS genres;
+DROP FUNCTION IF EXISTS genre_slug(text);
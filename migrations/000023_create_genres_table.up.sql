@@ -0,0 +1,61 @@
+-- genre_slug() normalizes a genre name the same way as data.GenreSlug()
+CREATE OR REPLACE FUNCTION genre_slug(name text) RETURNS text AS $$
+    SELECT trim(both '-' from regexp_replace(lower(name), '[^a-z0-9]+', '-', 'g'));
+$$ LANGUAGE sql IMMUTABLE;
+
+CREATE TABLE IF NOT EXISTS genres (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    slug text UNIQUE NOT NULL,
+    name text NOT NULL,
+    aliases text[] NOT NULL DEFAULT '{}',
+    version integer NOT NULL DEFAULT 1
+);
+
+INSERT INTO genres (slug, name, aliases)
+VALUES
+    ('action', 'Action', '{}'),
+    ('adventure', 'Adventure', '{}'),
+    ('animation', 'Animation', '{animated}'),
+    ('comedy', 'Comedy', '{}'),
+    ('crime', 'Crime', '{}'),
+    ('documentary', 'Documentary', '{}'),
+    ('drama', 'Drama', '{}'),
+    ('family', 'Family', '{}'),
+    ('fantasy', 'Fantasy', '{}'),
+    ('history', 'History', '{historical}'),
+    ('horror', 'Horror', '{}'),
+    ('music', 'Music', '{}'),
+    ('mystery', 'Mystery', '{}'),
+    ('romance', 'Romance', '{romantic}'),
+    ('science-fiction', 'Science Fiction', '{sci-fi,scifi,sf}'),
+    ('thriller', 'Thriller', '{}'),
+    ('war', 'War', '{}'),
+    ('western', 'Western', '{}')
+ON CONFLICT DO NOTHING;
+
+-- Add the genres already used by movies which the list above doesn't cover.
+INSERT INTO genres (slug, name)
+SELECT DISTINCT ON (slug) slug, initcap(trim(value))
+FROM (SELECT value, genre_slug(value) AS slug FROM movies, unnest(movies.genres) AS value) AS used
+WHERE slug <> '' AND NOT EXISTS (SELECT 1 FROM genres WHERE genres.slug = used.slug OR used.slug = ANY(genres.aliases))
+ORDER BY slug, value
+ON CONFLICT DO NOTHING;
+
+-- Replace the genres of every movie by their slugs, merging the values which turn out to be
+-- the same genre. Values which can't be resolved, such as names without any ASCII letter or
+-- digit, are kept as they are rather than silently dropped; only genres given when updating a
+-- movie are checked against the taxonomy.
+WITH named AS (
+    SELECT movies.id, value.position, COALESCE(genre.slug, value.name) AS genre
+    FROM movies
+    CROSS JOIN LATERAL unnest(movies.genres) WITH ORDINALITY AS value(name, position)
+    LEFT JOIN genres AS genre ON genre.slug = genre_slug(value.name) OR genre_slug(value.name) = ANY(genre.aliases)
+), resolved AS (
+    SELECT id, array_agg(genre ORDER BY position) AS genres
+    FROM (SELECT id, genre, min(position) AS position FROM named GROUP BY id, genre) AS deduplicated
+    GROUP BY id
+)
+UPDATE movies SET genres = resolved.genres, version = movies.version + 1
+FROM resolved
+WHERE movies.id = resolved.id AND movies.genres IS DISTINCT FROM resolved.genres;
@@ -0,0 +1,6 @@
+-- Locks put by an administrator are kept apart from the automatic lockout, which the
+-- user may lift themselves with the unlock email or a password reset.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS admin_locked_until timestamp(0) with time zone;
+
+UPDATE users SET admin_locked_until = locked_until, locked_until = NULL
+WHERE failed_logins = 0 AND locked_until > NOW();